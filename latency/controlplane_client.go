@@ -3,199 +3,4048 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"log/syslog"
+	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/MdBaizil/scion-homeworks/latency/probe"
 	"github.com/scionproto/scion/go/lib/addr"
-	"github.com/scionproto/scion/go/lib/common"
-	"github.com/scionproto/scion/go/lib/hpkt"
-	"github.com/scionproto/scion/go/lib/overlay"
 	"github.com/scionproto/scion/go/lib/sciond"
-	"github.com/scionproto/scion/go/lib/scmp"
 	"github.com/scionproto/scion/go/lib/snet"
-	"github.com/scionproto/scion/go/lib/sock/reliable"
-	"github.com/scionproto/scion/go/lib/spath"
 	"github.com/scionproto/scion/go/lib/spath/spathmeta"
-	"github.com/scionproto/scion/go/lib/spkt"
 )
 
 const (
 	NUM_ITERS = 20
-	MAX_NUM_TRIES = 40
+	// Defaults of -until-stable, the cap applies unless -count is given
+	DEFAULT_STABLE_WINDOW = 10
+	DEFAULT_STABLE_STDDEV = 0.5
+	DEFAULT_STABLE_MAX = 500
+	DEFAULT_TRIES_PER_SAMPLE = 2
+	DEFAULT_HIST_BINS = 10
+	DEFAULT_SPARK_WIDTH = 20
+	HIST_WIDTH = 40
+	DEFAULT_MAX_SAMPLES_MEMORY = 100000
+	CHANGE_WINDOW = 20
+
+	FORMAT_NDJSON = "ndjson"
+	FORMAT_CSV = "csv"
+
+	// Time limit of the request posting a Grafana annotation
+	GRAFANA_TIMEOUT = 5 * time.Second
+
+	// Packetization interval of common voice codecs, the cadence of -voip
+	VOIP_INTERVAL = 20 * time.Millisecond
+	DEFAULT_VOIP_LOSS = 1.0
+
+	TIE_HOPS = "hops"
+	TIE_MTU = "mtu"
+	TIE_FIRST = "first"
+
+	// Exit codes, 1 is used by check and 2 by the flag package
+	EXIT_NO_REPLY = 4
+	EXIT_REGRESSION = 5
+	EXIT_SLO_MISSED = 6
+
+	DEFAULT_REGRESS_THRESHOLD = 10.0
+	// RTT in ms that halves a destination's -weights score
+	SCORE_RTT_SCALE = 100.0
+
+	// With -measure-at the warm-up probes start this long before the window
+	WARMUP_LEAD = 5 * time.Second
+	DEFAULT_WARMUP = 3
 )
 
+// Loss percentages that -on-change reports a crossing of
+var LOSS_BOUNDARIES = []float64{5, 25, 50}
+
+// Config file keys that are spelled differently from their flag
+var CONFIG_ALIASES = map[string]string{"source": "s", "destination": "d", "verbose": "v"}
+
 var Seed rand.Source
 
-func createScmpEchoReqPkt(local *snet.Addr, remote *snet.Addr) (uint64, *spkt.ScnPkt) {
-	id := rand.New(Seed).Uint64()
-	info := &scmp.InfoEcho{Id: id, Seq: 0}
+// Guards Seed, a rand.Source must not be used by several goroutines at once
+var seedMutex sync.Mutex
 
-	scmpMeta := scmp.Meta{InfoLen: uint8(info.Len() / common.LineLen)}
-	pld := make(common.RawBytes, scmp.MetaLen+info.Len())
-	scmpMeta.Write(pld)
-	info.Write(pld[scmp.MetaLen:])
-	scmpHdr := scmp.NewHdr(scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoRequest}, len(pld))
+// Pseudonyms of -anonymize, nil to output addresses as they are
+var Anon *anonymizer
 
-	pkt := &spkt.ScnPkt{
-		DstIA:   remote.IA,
-		SrcIA:   local.IA,
-		DstHost: remote.Host,
-		SrcHost: local.Host,
-		Path:    remote.Path,
-		L4:      scmpHdr,
-		Pld:     pld,
-	}
+// Replaces hosts, and with hideAS the AS numbers, by keyed hashes. The key is random for every
+// run, so an address maps to the same pseudonym throughout a run but cannot be recovered by
+// hashing candidate addresses; the ISD is kept.
+type anonymizer struct {
+	key    []byte
+	hideAS bool
+}
+
+var (
+	// A host follows the IA and a comma in a SCION address, e.g. 1-ff00:0:110,[10.0.0.1]:30041
+	ANON_HOST = regexp.MustCompile(`,\[([^\]]+)\]`)
+	ANON_IA   = regexp.MustCompile(`\b(\d+)-([0-9a-f]+:[0-9a-f]+:[0-9a-f]+|\d+)\b`)
+)
 
-	return id, pkt
+func newAnonymizer(hideAS bool) *anonymizer {
+	key := make([]byte, 16)
+	_, err := crand.Read(key)
+	check(err)
+	return &anonymizer{key: key, hideAS: hideAS}
 }
 
+func (a *anonymizer) pseudonym(kind string, value string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + value))
+	return kind + hex.EncodeToString(mac.Sum(nil)[:4])
+}
 
-func validatePkt(pkt *spkt.ScnPkt, id uint64) (*scmp.Hdr, *scmp.InfoEcho, error) {
-	scmpHdr, ok := pkt.L4.(*scmp.Hdr)
-	if !ok {
-		return nil, nil,
-			common.NewBasicError("Not an SCMP header", nil, "type", common.TypeOf(pkt.L4))
+// Anonymizes every address and, with hideAS, every IA in s
+func (a *anonymizer) text(s string) string {
+	if a == nil {
+		return s
 	}
-	scmpPld, ok := pkt.Pld.(*scmp.Payload)
-	if !ok {
-		return nil, nil,
-			common.NewBasicError("Not an SCMP payload", nil, "type", common.TypeOf(pkt.Pld))
+	s = ANON_HOST.ReplaceAllStringFunc(s, func(m string) string {
+		return ",[" + a.pseudonym("host", m[2:len(m)-1]) + "]"
+	})
+	if a.hideAS {
+		s = ANON_IA.ReplaceAllStringFunc(s, func(m string) string {
+			parts := ANON_IA.FindStringSubmatch(m)
+			return parts[1] + "-" + a.pseudonym("as", parts[2])
+		})
 	}
-	info, ok := scmpPld.Info.(*scmp.InfoEcho)
-	if !ok {
-		return nil, nil,
-			common.NewBasicError("Not an Info Echo", nil, "type", common.TypeOf(info))
+	return s
+}
+
+// Log output with the addresses anonymized, warnings and errors quote them from replies and flags
+type anonymizedLog struct {
+	out io.Writer
+}
+
+func (w anonymizedLog) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(w.out, Anon.text(string(b))); err != nil {
+		return 0, err
 	}
-	return scmpHdr, info, nil
+	return len(b), nil
 }
 
-func check(e error) {
-	if e != nil {
-		log.Fatal(e)
+// Prints a progress message of -v with its addresses anonymized
+func progressf(format string, args ...interface{}) {
+	fmt.Print(Anon.text(fmt.Sprintf(format, args...)))
+}
+
+// Build metadata, set at build time with
+// go build -ldflags "-X main.Version=... -X main.Commit=$(git rev-parse HEAD) -X main.BuildDate=$(date -u +%FT%TZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+func lateStats(late []int64) *probe.LateStats {
+	if len(late) == 0 {
+		return nil
+	}
+	sorted := make([]int64, len(late))
+	copy(sorted, late)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &probe.LateStats{
+		Replies:   len(sorted),
+		RTTMin:    float64(sorted[0]) / 1e6,
+		RTTMedian: float64(probe.Percentile(sorted, 50)) / 1e6,
+		RTTMax:    float64(sorted[len(sorted)-1]) / 1e6,
 	}
 }
 
-func printUsage() {
-	fmt.Println("\nrandom_speedclient -s SourceSCIONAddress -d DestinationSCIONAddress")
-	fmt.Println("\tProvides speed estimates (RTT and latency) from source to desination")
-	fmt.Println("\tThe SCION address is specified as ISD-AS,[IP Address]:Port")
-	fmt.Println("\tIf source port unspecified, a random available one will be used.")
-	fmt.Println("\tExample SCION address 1-1,[127.0.0.1]:42002\n")
+func randomFloat64() float64 {
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+	return rand.New(Seed).Float64()
 }
 
-func main() {
-	var (
-		sourceAddress string
-		destinationAddress string
+// Exponentially distributed with mean 1, the gaps between the events of a Poisson process of rate 1
+func randomExpFloat64() float64 {
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+	return rand.New(Seed).ExpFloat64()
+}
 
-		err    error
-		local  *snet.Addr
-		remote *snet.Addr
+func randomIntn(n int) int {
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+	return rand.New(Seed).Intn(n)
+}
 
-		scmpConnection *reliable.Conn
-	)
+func printSeries(series []*probe.SecondStats) {
+	fmt.Println("\nsecond,sent,replies,rtt_min_ms,rtt_avg_ms,rtt_max_ms,partial")
+	for _, stats := range series {
+		if stats.RTTAvg != nil {
+			fmt.Printf("%d,%d,%d,%.3f,%.3f,%.3f,%t\n", stats.Second, stats.Sent, stats.Replies,
+				*stats.RTTMin, *stats.RTTAvg, *stats.RTTMax, stats.Partial)
+		} else {
+			fmt.Printf("%d,%d,%d,,,,%t\n", stats.Second, stats.Sent, stats.Replies, stats.Partial)
+		}
+	}
+}
 
-	// Fetch arguments from command line
-	flag.StringVar(&sourceAddress, "s", "", "Source SCION Address")
-	flag.StringVar(&destinationAddress, "d", "", "Destination SCION Address")
-	flag.Parse()
+func loadBaseline(filename string) (*probe.Result, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	baseline := &probe.Result{}
+	if err := json.Unmarshal(data, baseline); err != nil {
+		return nil, fmt.Errorf("Error, %s is not a JSON result: %v", filename, err)
+	}
+	if baseline.Samples == 0 || baseline.RTTAvg <= 0 {
+		return nil, fmt.Errorf("Error, %s holds no RTT measurement", filename)
+	}
+	return baseline, nil
+}
 
-	// Create the SCION UDP socket
-	if len(sourceAddress) > 0 {
-		local, err = snet.AddrFromString(sourceAddress)
-		check(err)
-	} else {
-		printUsage()
-		check(fmt.Errorf("Error, source address needs to be specified with -s"))
+func compareToBaseline(result *probe.Result, baseline *probe.Result, filename string,
+	threshold float64) *probe.Comparison {
+	c := &probe.Comparison{
+		File:      filename,
+		RTTAvg:    baseline.RTTAvg,
+		RTTDelta:  result.RTTAvg - baseline.RTTAvg,
+		RTTChange: 100 * (result.RTTAvg - baseline.RTTAvg) / baseline.RTTAvg,
+		Threshold: threshold,
 	}
-	if len(destinationAddress) > 0 {
-		remote, err = snet.AddrFromString(destinationAddress)
-		check(err)
+	c.Regression = c.RTTChange > threshold
+	// Baselines written before loss was reported can only be compared by RTT
+	if baseline.Loss != nil && result.Loss != nil {
+		delta := *result.Loss - *baseline.Loss
+		c.Loss = baseline.Loss
+		c.LossDelta = &delta
+		c.Regression = c.Regression || delta > threshold
+	}
+	return c
+}
+
+func printComparison(c *probe.Comparison) {
+	fmt.Printf("\nCompared to baseline %s:\n", c.File)
+	fmt.Printf("\tRTT - %.3fms -> %+.3fms (%+.1f%%)\n", c.RTTAvg, c.RTTDelta, c.RTTChange)
+	if c.LossDelta != nil {
+		fmt.Printf("\tLoss - %.1f%% -> %+.1f%%\n", *c.Loss, *c.LossDelta)
+	}
+	if c.Regression {
+		fmt.Printf("\tREGRESSION beyond the %.1f%% threshold\n", c.Threshold)
 	} else {
-		printUsage()
-		check(fmt.Errorf("Error, destination address needs to be specified with -d"))
+		fmt.Printf("\tNo regression beyond the %.1f%% threshold\n", c.Threshold)
 	}
+}
 
-	dispatcherAddr := "/run/shm/dispatcher/default.sock"
-	snet.Init(local.IA, sciond.GetDefaultSCIONDPath(nil), dispatcherAddr)
+// Record of one probe written with -output, the RTT is in ms and null if the probe failed.
+// With -abs-times the send and receive times are included as nanoseconds since the epoch.
+type ProbeRecord struct {
+	Seq      int      `json:"seq"`
+	RTT      *float64 `json:"rtt"`
+	Error    string   `json:"error,omitempty"`
+	Match    string   `json:"match,omitempty"`
+	Sent     *int64   `json:"sent_ns,omitempty"`
+	Received *int64   `json:"received_ns,omitempty"`
+}
 
-	localAppAddr := &reliable.AppAddr{Addr: local.Host, Port: local.L4Port}
-	scmpConnection, _, err = reliable.Register(dispatcherAddr, local.IA, localAppAddr, nil, addr.SvcNone)
-	check(err)
+// Writes a record for every probe to a file or stdout ("-"), gzip compressed if requested. With
+// rotate the file is replaced by a new one at every multiple of rotate, see rotatedName.
+type probeWriter struct {
+	file     *os.File
+	gz       *gzip.Writer
+	out      *bufio.Writer
+	csv      *csv.Writer // Over out, for the quoting of RFC 4180 with FORMAT_CSV
+	format   string
+	absTimes bool
+	closed   bool
+	path     string
+	compress bool
+	rotate   time.Duration
+	period   time.Time // Start of the rotation period of the open file
+}
 
-	// Get Path to Remote
-	var pathEntry *sciond.PathReplyEntry
-	var options spathmeta.AppPathSet
-	options = snet.DefNetwork.PathResolver().Query(local.IA, remote.IA)
-	if len(options) == 0 {
-		check(fmt.Errorf("Cannot find a path from source to destination"))
+func openProbeWriter(path string, format string, compress bool, absTimes bool,
+	rotate time.Duration) (*probeWriter, error) {
+	w := &probeWriter{file: os.Stdout, format: format, absTimes: absTimes, path: path, compress: compress,
+		rotate: rotate}
+	if rotate > 0 {
+		w.period = time.Now().Truncate(rotate)
+		return w, w.open(rotatedName(path, w.period))
 	}
+	if path == "-" {
+		w.start()
+		return w, nil
+	}
+	return w, w.open(path)
+}
 
-	for _, entry := range options {
-		pathEntry = entry.Entry /* Choose the first random one. */
-		break
+// File name of the rotation period starting at period: its UTC start is inserted before the
+// extensions of path, so probes.csv.gz becomes probes-20060102T150405Z.csv.gz
+func rotatedName(path string, period time.Time) string {
+	dir, base := filepath.Split(path)
+	stamp := "-" + period.UTC().Format("20060102T150405Z")
+	if i := strings.Index(base, "."); i > 0 {
+		return dir + base[:i] + stamp + base[i:]
 	}
+	return dir + base + stamp
+}
 
-	fmt.Println("Path:", pathEntry.Path.String())
-	remote.Path = spath.New(pathEntry.Path.FwdPath)
-	remote.Path.InitOffsets()
-	remote.NextHopHost = pathEntry.HostInfo.Host()
-	remote.NextHopPort = pathEntry.HostInfo.Port
-	remoteAppAddr := &reliable.AppAddr{Addr: remote.NextHopHost, Port: remote.NextHopPort}
-	if remote.NextHopHost == nil {
-		remoteAppAddr = &reliable.AppAddr{Addr: remote.Host, Port: overlay.EndhostPort}
+func (w *probeWriter) open(name string) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
 	}
+	w.file = file
+	w.start()
+	return nil
+}
 
-	Seed = rand.NewSource(time.Now().UnixNano())
+// Sets up the writers on the open file, every file of a rotation gets its own CSV header
+func (w *probeWriter) start() {
+	var dst io.Writer = w.file
+	if w.compress {
+		w.gz = gzip.NewWriter(w.file)
+		dst = w.gz
+	}
+	w.out = bufio.NewWriter(dst)
+	w.csv = csv.NewWriter(w.out)
+	if w.format == FORMAT_CSV && w.absTimes {
+		w.csv.Write([]string{"seq", "rtt_ms", "error", "sent_ns", "received_ns"})
+	} else if w.format == FORMAT_CSV {
+		w.csv.Write([]string{"seq", "rtt_ms", "error"})
+	}
+	w.csv.Flush()
+}
 
-	// Do 5 iterations so we can use average
-	var total int64 = 0
-	iters := 0
+// Completes the file of the past period and opens the next one. Records are only written between
+// probes, so every record lands whole in one file and none is lost at the switch.
+func (w *probeWriter) rotateIfDue() error {
+	period := time.Now().Truncate(w.rotate)
+	if !period.After(w.period) {
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	w.closed = false
+	w.gz = nil
+	w.period = period
+	return w.open(rotatedName(w.path, period))
+}
+
+func (w *probeWriter) write(record *ProbeRecord) error {
+	if w.rotate > 0 {
+		if err := w.rotateIfDue(); err != nil {
+			return err
+		}
+	}
+	if w.format == FORMAT_CSV {
+		rtt := ""
+		if record.RTT != nil {
+			rtt = strconv.FormatFloat(*record.RTT, 'f', 3, 64)
+		}
+		row := []string{strconv.Itoa(record.Seq), rtt, record.Error}
+		if w.absTimes {
+			sent, received := "", ""
+			if record.Sent != nil {
+				sent = strconv.FormatInt(*record.Sent, 10)
+			}
+			if record.Received != nil {
+				received = strconv.FormatInt(*record.Received, 10)
+			}
+			row = append(row, sent, received)
+		}
+		w.csv.Write(row)
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w.out, string(line))
+	return err
+}
+
+// Flushes and closes the output, safe to call more than once
+func (w *probeWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.out.Flush()
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if w.file != os.Stdout {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Streams "seq,rtt_ms" lines to a named pipe for live plotting. Lines are dropped while no reader
+// is connected, and writing resumes once a reader (re)opens the pipe.
+type pipeWriter struct {
+	path string
+	file *os.File
+}
+
+// Creates the named pipe if it does not exist yet
+func newPipeWriter(path string) (*pipeWriter, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		err = syscall.Mkfifo(path, 0644)
+	} else if err == nil && info.Mode()&os.ModeNamedPipe == 0 {
+		err = fmt.Errorf("Error, %s is not a named pipe", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pipeWriter{path: path}, nil
+}
+
+func (p *pipeWriter) write(seq int, rtt float64) {
+	if p.file == nil {
+		// Opening without a reader fails instead of blocking the measurement
+		file, err := os.OpenFile(p.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return
+		}
+		p.file = file
+	}
+	// Unbuffered, so every line is flushed to the reader right away
+	_, err := fmt.Fprintf(p.file, "%d,%.3f\n", seq, rtt)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EPIPE {
+		// The reader went away, pause until the pipe is reopened
+		p.file.Close()
+		p.file = nil
+	}
+}
+
+func (p *pipeWriter) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	return p.file.Close()
+}
+
+// Sends one probe of p. A probe too large for the path ends the run, every further one would fail.
+func exchange(p *probe.Prober) (probe.Reply, error) {
+	reply, err := p.Exchange(context.Background())
+	if errors.Is(err, probe.ErrTooLarge) {
+		check(fmt.Errorf("%v, use a smaller -size", err))
+	}
+	return reply, err
+}
+
+// RTT sample of the reply to probe seq
+func newSample(seq int, reply probe.Reply) probe.Sample {
+	return probe.Sample{Seq: seq, RTT: int64(reply.RTT()), Sent: reply.Sent.UnixNano()}
+}
+
+// Result of -interleave, the paired difference is the RTT of A minus the RTT of B in milliseconds
+type InterleavedResult struct {
+	A        *probe.Result  `json:"a"`
+	B        *probe.Result  `json:"b"`
+	Pairs    int      `json:"pairs"`
+	DiffAvg  float64  `json:"diff_avg"`
+	DiffCI95 *float64 `json:"diff_ci95"`
+}
+
+// Alternates probes between two destinations so that both see the same network conditions, until
+// count pairs of replies were received. The probers are called labelA and labelB in the output.
+func runInterleaved(a *probe.Prober, b *probe.Prober, labelA string, labelB string, count int, max_tries int,
+	interval time.Duration, verbose bool, jsonOutput bool) {
+	var samplesA, samplesB []probe.Sample
+	var diffs []int64
 	num_tries := 0
-	buff := make(common.RawBytes, pathEntry.Path.Mtu)
-	for iters < NUM_ITERS && num_tries < MAX_NUM_TRIES {
+	for len(diffs) < count && num_tries < max_tries {
+		if num_tries > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
 		num_tries += 1
 
-		// Construct SCMP Packet
-		id, pkt := createScmpEchoReqPkt(local, remote)
-		pktLen, err := hpkt.WriteScnPkt(pkt, buff)
+		replyA, errA := exchange(a)
+		if errA == nil {
+			samplesA = append(samplesA, newSample(num_tries, replyA))
+		} else if verbose {
+			progressf("Probe %d to %s: %v\n", num_tries, labelA, errA)
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+		replyB, errB := exchange(b)
+		if errB == nil {
+			samplesB = append(samplesB, newSample(num_tries, replyB))
+		} else if verbose {
+			progressf("Probe %d to %s: %v\n", num_tries, labelB, errB)
+		}
+
+		if errA == nil && errB == nil {
+			diffs = append(diffs, samplesA[len(samplesA)-1].RTT-samplesB[len(samplesB)-1].RTT)
+		}
+	}
+
+	if len(samplesA) == 0 || len(samplesB) == 0 {
+		log.Printf("Error, no reply received from one of the destinations after %d attempts", num_tries)
+		exit(EXIT_NO_REPLY)
+	}
+	if len(diffs) != count {
+		check(probe.GaveUp(num_tries, len(diffs), count))
+	}
+
+	result := &InterleavedResult{
+		A:     newResult(a.Local().String(), a.Remote().String(), samplesA),
+		B:     newResult(b.Local().String(), b.Remote().String(), samplesB),
+		Pairs: len(diffs),
+	}
+	result.A.Mode, result.B.Mode = a.Config().Mode, b.Config().Mode
+	result.A.BytesSent, result.B.BytesSent = a.Stats().BytesSent, b.Stats().BytesSent
+	result.A.PathExpiry, result.B.PathExpiry = formatExpiry(a.PathExpiry()), formatExpiry(b.PathExpiry())
+	mean, stderr := probe.MeanAndStdErr(diffs)
+	result.DiffAvg = mean / 1e6
+	if len(diffs) > 1 {
+		ci := probe.ConfidenceInterval95(stderr, len(diffs)) / 1e6
+		result.DiffCI95 = &ci
+	}
+
+	if jsonOutput {
+		out, err := json.MarshalIndent(result, "", "  ")
 		check(err)
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Printf("\n%s:", labelA)
+	printSummary(result.A)
+	fmt.Printf("\n%s:", labelB)
+	printSummary(result.B)
+	if result.DiffCI95 != nil {
+		fmt.Printf("\nPaired difference (%s - %s) over %d pairs: %+.3f ± %.3fms (95%% CI)\n",
+			labelA, labelB, result.Pairs, result.DiffAvg, *result.DiffCI95)
+	} else {
+		fmt.Printf("\nPaired difference (%s - %s) over %d pairs: %+.3fms\n", labelA, labelB, result.Pairs,
+			result.DiffAvg)
+	}
+}
+
+// Reads the destinations of -f, one SCION address per line, skipping blank lines and # comments
+func readDestinations(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var destinations []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		destinations = append(destinations, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("Error, no destinations in %s", filename)
+	}
+	return destinations, nil
+}
+
+// Prints the path of the prober unless quiet, and details of the path selection if verbose
+func printPath(p *probe.Prober, verbose bool, quiet bool) {
+	selection := p.Selection()
+	path := p.Path().Path.String()
+	if selection.Loaded {
+		if !quiet {
+			fmt.Println("Path (loaded):", Anon.text(path))
+		}
+		return
+	}
+	config := p.Config()
+	if config.Policy != nil && !quiet {
+		fmt.Printf("Paths matching policy %q: %d of %d\n", Anon.text(config.Policy.String()), selection.Matching,
+			selection.Resolved)
+	}
+	if verbose {
+		progressf("Chose path out of %d: %s\n", selection.Matching, selection.Reason)
+	}
+	if !quiet {
+		fmt.Println("Path:", Anon.text(path))
+	}
+	if config.ToBorder && !quiet {
+		fmt.Println("Measuring to the border router of", Anon.text(p.Remote().IA.String()),
+			"instead of the destination host")
+	}
+}
+
+// Measures count samples to destination with a prober configured by config, nil if no reply was
+// received at all
+func measureDestination(local *snet.Addr, config probe.Config, destination string, count int, max_tries int,
+	interval time.Duration, verbose bool, quiet bool) *probe.Result {
+	remote, err := snet.AddrFromString(destination)
+	check(err)
+	prober, err := probe.New(local, remote, config)
+	check(err)
+	printPath(prober, verbose, quiet)
+
+	var samples []probe.Sample
+	num_tries := 0
+	for len(samples) < count && num_tries < max_tries {
+		if num_tries > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		num_tries += 1
+		reply, err := exchange(prober)
+		if err != nil {
+			if verbose {
+				progressf("Probe %d to %s: %v\n", num_tries, destination, err)
+			}
+			continue
+		}
+		samples = append(samples, newSample(num_tries, reply))
+	}
+	// Free the source port for the next destination
+	prober.Close()
+
+	if len(samples) == 0 {
+		log.Printf("Warning, no reply received from %s after %d attempts", destination, num_tries)
+		return nil
+	}
+	result := newResult(local.String(), destination, samples)
+	result.Mode = config.Mode
+	result.ToBorder = config.ToBorder
+	result.Size = config.Size
+	result.BytesSent = prober.Stats().BytesSent
+	loss := 100 * float64(num_tries-len(samples)) / float64(num_tries)
+	result.Loss = &loss
+	return result
+}
+
+// Latency budget of a -chain of destinations: the RTT to each segment and the running total in ms
+type ChainResult struct {
+	Segments   []*probe.Result `json:"segments"`
+	Cumulative []float64 `json:"cumulative"`
+	Total      float64   `json:"total"`
+}
 
+// Splits the -chain list at the commas between addresses, not the ones inside them ("1-1,[::1]:80")
+func splitChain(list string) []string {
+	var destinations []string
+	for _, field := range strings.Split(list, ",") {
+		if strings.HasPrefix(field, "[") && len(destinations) > 0 {
+			destinations[len(destinations)-1] += "," + field
+		} else {
+			destinations = append(destinations, field)
+		}
+	}
+	return destinations
+}
+
+// Measures the destinations of a chain in order, as the services a request traverses one after
+// the other. Every segment is measured from this host, the total assumes the request returns
+// here between services. A segment without replies leaves the total undefined, so it fails.
+func runChain(local *snet.Addr, config probe.Config, destinations []string, count int, max_tries int,
+	interval time.Duration, verbose bool, jsonOutput bool) {
+	chain := &ChainResult{}
+	for _, destination := range destinations {
+		result := measureDestination(local, config, destination, count, max_tries, interval, verbose, jsonOutput)
+		if result == nil {
+			log.Printf("Error, chain broken at %s", destination)
+			exit(EXIT_NO_REPLY)
+		}
+		chain.Total += result.RTTAvg
+		chain.Segments = append(chain.Segments, result)
+		chain.Cumulative = append(chain.Cumulative, chain.Total)
+	}
 
-		time_sent := time.Now()
-		_, err = scmpConnection.WriteTo(buff[:pktLen], remoteAppAddr)
+	if jsonOutput {
+		out, err := json.MarshalIndent(chain, "", "  ")
 		check(err)
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Println("\nChain:")
+	for i, segment := range chain.Segments {
+		fmt.Printf("\t%d. %s - %.3fms (loss %.1f%%), cumulative %.3fms\n", i+1, segment.Destination,
+			segment.RTTAvg, *segment.Loss, chain.Cumulative[i])
+	}
+	fmt.Printf("Total - %.3fms over %d segments\n", chain.Total, len(chain.Segments))
+}
+
+// Reads the -weights file: a destination and its weight per line, blank lines and lines starting
+// with # are skipped
+func readWeights(filename string) (map[string]float64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	weights := make(map[string]float64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Error, invalid weight line %q, expected DESTINATION WEIGHT", line)
+		}
+		weight, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("Error, invalid weight %q for %s", fields[1], fields[0])
+		}
+		weights[fields[0]] = weight
+	}
+	return weights, scanner.Err()
+}
+
+// Score of a destination from 0 to 100: the share of probes answered, scaled down by the RTT so
+// that one of SCORE_RTT_SCALE halves it. A destination without any reply scores 0.
+func destinationScore(result *probe.Result) float64 {
+	if result == nil {
+		return 0
+	}
+	return (100 - *result.Loss) * SCORE_RTT_SCALE / (SCORE_RTT_SCALE + result.RTTAvg)
+}
+
+// Connectivity score of a -f batch with -weights: the weighted mean of the destinations' scores,
+// every run of a destination counts with its weight
+type BatchScore struct {
+	Results      []*probe.Result           `json:"results"`
+	Destinations []*DestinationScore `json:"destinations"`
+	Score        float64             `json:"score"`
+}
+
+type DestinationScore struct {
+	Destination string  `json:"destination"`
+	Weight      float64 `json:"weight"`
+	Score       float64 `json:"score"`
+	Runs        int     `json:"runs"`
+}
 
-		n, err := scmpConnection.Read(buff)
-		time_received := time.Now()
+// Measures every destination in turn, runs times over. Each destination gets its own prober
+// configured by config, a destination that does not reply is reported and skipped. With
+// weights, destinations that are not listed weigh 1, the batch is summarized as a BatchScore. With
+// shuffle, the orders are drawn from their own source of seed, so the measurements' draws from Seed
+// do not change them and the same seed repeats them.
+func runBatch(local *snet.Addr, config probe.Config, destinations []string, runs int, shuffle bool, seed int64,
+	count int, max_tries int, interval time.Duration, verbose bool, jsonOutput bool, weights map[string]float64) {
+	shuffler := rand.New(rand.NewSource(seed))
+	var results []*probe.Result
+	var batchScore *BatchScore
+	byDestination := make(map[string]*DestinationScore)
+	var weightedSum, weightTotal float64
+	if weights != nil {
+		batchScore = &BatchScore{}
+		for _, destination := range destinations {
+			weight, ok := weights[destination]
+			if !ok {
+				weight = 1
+			}
+			score := &DestinationScore{Destination: Anon.text(destination), Weight: weight}
+			byDestination[destination] = score
+			batchScore.Destinations = append(batchScore.Destinations, score)
+		}
+	}
+	for run := 1; run <= runs; run++ {
+		order := append([]string(nil), destinations...)
+		if shuffle {
+			for i := len(order) - 1; i > 0; i-- {
+				j := shuffler.Intn(i + 1)
+				order[i], order[j] = order[j], order[i]
+			}
+		}
+		if verbose {
+			progressf("Run %d order: %s\n", run, strings.Join(order, " "))
+		}
 
-		recvpkt := &spkt.ScnPkt{}
-		err = hpkt.ParseScnPkt(recvpkt, buff[:n])
+		for _, destination := range order {
+			result := measureDestination(local, config, destination, count, max_tries, interval, verbose,
+				jsonOutput)
+			// A destination without replies still counts, with a score of 0
+			if score := byDestination[destination]; score != nil {
+				runScore := destinationScore(result)
+				score.Score += (runScore - score.Score) / float64(score.Runs+1)
+				score.Runs += 1
+				weightedSum += score.Weight * runScore
+				weightTotal += score.Weight
+			}
+			if result == nil {
+				continue
+			}
+			if shuffle {
+				result.Seed = &seed
+			}
+			results = append(results, result)
+			if !jsonOutput {
+				fmt.Printf("\nRun %d:", run)
+				printSummary(result)
+			}
+		}
+	}
+
+	if batchScore != nil && weightTotal > 0 {
+		batchScore.Score = weightedSum / weightTotal
+	}
+	if jsonOutput && batchScore != nil {
+		batchScore.Results = results
+		out, err := json.MarshalIndent(batchScore, "", "  ")
 		check(err)
-		_, info, err := validatePkt(recvpkt, id)
+		fmt.Println(string(out))
+	} else if jsonOutput {
+		out, err := json.MarshalIndent(results, "", "  ")
 		check(err)
+		fmt.Println(string(out))
+	} else if batchScore != nil {
+		fmt.Println("\nConnectivity score:")
+		for _, score := range batchScore.Destinations {
+			fmt.Printf("\t%s - %.1f (weight %g, %d runs)\n", score.Destination, score.Score, score.Weight, score.Runs)
+		}
+		fmt.Printf("Score - %.1f of 100\n", batchScore.Score)
+	}
+	if len(results) == 0 {
+		log.Printf("Error, no reply received from any of the %d destinations", len(destinations))
+		exit(EXIT_NO_REPLY)
+	}
+}
+
+// RTT to one hop of the path measured with -per-interface, in ms and null if the hop never replied
+type HopResult struct {
+	Hop       int      `json:"hop"`
+	Interface string   `json:"interface,omitempty"`
+	Sent      int      `json:"sent"`
+	Replies   int      `json:"replies"`
+	RTTAvg    *float64 `json:"rtt_avg"`
+}
+
+// Sends probes traceroute requests to the router of every hop field of the prober's path in turn,
+// see probe.Prober.ProbeHop
+func probePerInterface(prober *probe.Prober, probes int, interval time.Duration, verbose bool) []*HopResult {
+	offsets, err := prober.HopOffsets()
+	check(err)
 
-		if info.Id == id {
-			diff := (time_received.UnixNano() - time_sent.UnixNano())
-			total += diff
-			iters += 1
-			// fmt.Printf("%d: %.3fms %.3fms\n", iters, float64(diff)/1e6, float64(diff)/2e6)
+	var results []*HopResult
+	for i, offset := range offsets {
+		result := &HopResult{Hop: i + 1, Sent: probes}
+		var rtts []int64
+		for j := 0; j < probes; j++ {
+			if j > 0 && interval > 0 {
+				time.Sleep(interval)
+			}
+			reply, err := prober.ProbeHop(context.Background(), offset)
+			if err != nil {
+				if verbose {
+					progressf("Hop %d, probe %d: %v\n", i+1, j+1, err)
+				}
+				continue
+			}
+			rtts = append(rtts, int64(reply.RTT()))
+			result.Interface = Anon.text(reply.Hop)
 		}
+		result.Replies = len(rtts)
+		if len(rtts) > 0 {
+			mean, _ := probe.MeanAndStdErr(rtts)
+			avg := mean / 1e6
+			result.RTTAvg = &avg
+		}
+		results = append(results, result)
 	}
+	return results
+}
 
-	if iters != NUM_ITERS {
-		check(fmt.Errorf("Error, exceeded maximum number of attempts"))
+func printPerInterface(results []*HopResult) {
+	fmt.Println("\nRTT per interface along the path:")
+	for _, r := range results {
+		if r.RTTAvg != nil {
+			fmt.Printf("\t%2d. %-24s %9.3fms (%d/%d replies)\n", r.Hop, r.Interface, *r.RTTAvg, r.Replies, r.Sent)
+		} else {
+			fmt.Printf("\t%2d. *\n", r.Hop)
+		}
 	}
+}
 
-	var difference float64 = float64(total) / float64(iters)
+// Payload sizes of -size-sweep, from lo to hi in steps of step bytes
+type sizeSweep struct {
+	lo, hi, step int
+}
 
-	fmt.Printf("\nSource: %s\nDestination: %s\n", sourceAddress, destinationAddress);
-	fmt.Println("Time estimates:")
-	// Print in ms, so divide by 1e6 from nano
-	fmt.Printf("\tRTT - %.3fms\n", difference/1e6)
-	fmt.Printf("\tLatency - %.3fms\n", difference/2e6)
+func parseSizeSweep(spec string) (*sizeSweep, error) {
+	bounds := strings.Split(spec, ":")
+	if len(bounds) != 3 {
+		return nil, fmt.Errorf("Error, invalid size sweep %q, expected LO:HI:STEP", spec)
+	}
+	var values [3]int
+	for i, bound := range bounds {
+		v, err := strconv.Atoi(bound)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("Error, size sweep %q must be three non-negative integers", spec)
+		}
+		values[i] = v
+	}
+	sweep := &sizeSweep{lo: values[0], hi: values[1], step: values[2]}
+	if sweep.step == 0 || sweep.lo > sweep.hi {
+		return nil, fmt.Errorf("Error, size sweep %q is empty, LO must not exceed HI and STEP must be positive",
+			spec)
+	}
+	return sweep, nil
+}
+
+// RTT of the probes of one payload size of -size-sweep, in ms and null if none was answered.
+// Bytes is the length of the probe packets, Timeouts the probes that were lost without an error.
+type SizeResult struct {
+	Size     int      `json:"size"`
+	Bytes    int      `json:"bytes"`
+	Sent     int      `json:"sent"`
+	Replies  int      `json:"replies"`
+	Timeouts int      `json:"timeouts"`
+	RTTAvg   *float64 `json:"rtt_avg"`
+}
+
+// Suspected path MTU black hole of a -size-sweep: from FirstFailing on, probes that fit the
+// path MTU were dropped without an SCMP error, while smaller ones got through. The largest probe
+// that got through, LargestOK payload bytes in packets of WorkingMTU bytes, is the MTU that works.
+type BlackHole struct {
+	FirstFailing int `json:"first_failing"`
+	LargestOK    int `json:"largest_ok"`
+	WorkingMTU   int `json:"working_mtu"`
+}
+
+// A black hole is suspected if some sizes were answered and every larger size only timed out, so
+// that loss scattered over the sizes is not mistaken for one
+func detectBlackHole(sizes []*SizeResult) *BlackHole {
+	lastOK := -1
+	for i, r := range sizes {
+		if r.Replies > 0 {
+			lastOK = i
+		}
+	}
+	if lastOK < 0 || lastOK == len(sizes)-1 {
+		return nil
+	}
+	for _, r := range sizes[lastOK+1:] {
+		if r.Timeouts < r.Sent {
+			return nil
+		}
+	}
+	return &BlackHole{
+		FirstFailing: sizes[lastOK+1].Size,
+		LargestOK:    sizes[lastOK].Size,
+		WorkingMTU:   sizes[lastOK].Bytes,
+	}
+}
+
+// Result of -size-sweep. FirstFailing is the smallest size without any reply, TooLarge the first
+// one that did not fit the MTU sciond reports for the path, the sweep stops there. The slope is
+// the RTT added per 100 bytes of payload, by least squares over the sizes that were answered.
+type SweepResult struct {
+	MTU          int           `json:"mtu"`
+	Sizes        []*SizeResult `json:"sizes"`
+	FirstFailing *int          `json:"first_failing,omitempty"`
+	TooLarge     *int          `json:"too_large,omitempty"`
+	Slope        *float64      `json:"slope_per_100b,omitempty"`
+	BlackHole    *BlackHole    `json:"black_hole,omitempty"`
+}
+
+// Sends probes probes of every size of the sweep over the prober's path, smallest first
+func runSizeSweep(prober *probe.Prober, sweep *sizeSweep, probes int, interval time.Duration,
+	verbose bool) *SweepResult {
+	result := &SweepResult{MTU: prober.MTU()}
+	var sizes, rtts []float64
+	for size := sweep.lo; size <= sweep.hi; size += sweep.step {
+		prober.SetSize(size)
+		r := &SizeResult{Size: size}
+		var samples []int64
+		for j := 0; j < probes; j++ {
+			if (j > 0 || size > sweep.lo) && interval > 0 {
+				time.Sleep(interval)
+			}
+			reply, err := prober.Exchange(context.Background())
+			if errors.Is(err, probe.ErrTooLarge) {
+				break
+			}
+			r.Sent += 1
+			r.Bytes = reply.Bytes
+			if err != nil {
+				if errors.Is(err, probe.ErrTimeout) {
+					r.Timeouts += 1
+				}
+				if verbose {
+					progressf("Size %d, probe %d: %v\n", size, j+1, err)
+				}
+				continue
+			}
+			samples = append(samples, int64(reply.RTT()))
+		}
+		if r.Sent == 0 {
+			tooLarge := size
+			result.TooLarge = &tooLarge
+			break
+		}
+		r.Replies = len(samples)
+		if len(samples) > 0 {
+			mean, _ := probe.MeanAndStdErr(samples)
+			avg := mean / 1e6
+			r.RTTAvg = &avg
+			sizes = append(sizes, float64(size))
+			rtts = append(rtts, avg)
+		} else if result.FirstFailing == nil {
+			failing := size
+			result.FirstFailing = &failing
+		}
+		result.Sizes = append(result.Sizes, r)
+	}
+	result.Slope = leastSquaresSlope(sizes, rtts)
+	if result.Slope != nil {
+		*result.Slope *= 100
+	}
+	result.BlackHole = detectBlackHole(result.Sizes)
+	return result
+}
+
+// Slope of the least squares line through the points, nil for fewer than two distinct x
+func leastSquaresSlope(x []float64, y []float64) *float64 {
+	n := float64(len(x))
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX float64
+	for i := range x {
+		cov += (x[i] - meanX) * (y[i] - meanY)
+		varX += (x[i] - meanX) * (x[i] - meanX)
+	}
+	if varX == 0 {
+		return nil
+	}
+	slope := cov / varX
+	return &slope
+}
+
+func printSizeSweep(result *SweepResult) {
+	fmt.Printf("\nRTT per payload size (path MTU %d bytes):\n", result.MTU)
+	for _, r := range result.Sizes {
+		if r.RTTAvg != nil {
+			fmt.Printf("\t%6dB %9.3fms (%d/%d replies)\n", r.Size, *r.RTTAvg, r.Replies, r.Sent)
+		} else {
+			fmt.Printf("\t%6dB %9s (0/%d replies)\n", r.Size, "*", r.Sent)
+		}
+	}
+	if result.Slope != nil {
+		fmt.Printf("Serialization cost - %.3fms per 100 bytes\n", *result.Slope)
+	}
+	if result.BlackHole != nil {
+		fmt.Printf("Suspected path MTU black hole: probes from %d bytes on were dropped silently although\n",
+			result.BlackHole.FirstFailing)
+		fmt.Printf("\tthey fit the path MTU of %d bytes; the largest that got through was %d bytes, packets of\n",
+			result.MTU, result.BlackHole.LargestOK)
+		fmt.Printf("\t%d bytes, which is the MTU that works on this path\n", result.BlackHole.WorkingMTU)
+	} else if result.FirstFailing != nil {
+		fmt.Printf("Replies fail from %d bytes, likely the path MTU\n", *result.FirstFailing)
+	}
+	if result.TooLarge != nil {
+		fmt.Printf("Stopped at %d bytes, the probe does not fit the path MTU\n", *result.TooLarge)
+	}
+}
+
+// RTT series of -bidir, in ms and null for a lost probe: Forward of the client's probes, Reverse
+// of the server's. The correlation is over the sequence numbers both directions have an RTT for.
+type BidirResult struct {
+	Forward     *probe.Result    `json:"forward"`
+	Reverse     *probe.Result    `json:"reverse"`
+	ForwardRTTs []*float64 `json:"forward_rtts"`
+	ReverseRTTs []*float64 `json:"reverse_rtts"`
+	Correlation *float64   `json:"correlation"`
 }
 
+// Probes the UDP responder while it probes back, see probe.Prober.Bidir
+func runBidir(prober *probe.Prober, count int, interval time.Duration, verbose bool) *BidirResult {
+	series, err := prober.Bidir(count, interval)
+	check(err)
+	if series.Reported < count {
+		log.Printf("Warning, the responder reported %d of its %d probes, is it running with -bidir?",
+			series.Reported, count)
+	}
+	if series.Failed > 0 && verbose {
+		fmt.Printf("Writing %d of the %d probes failed\n", series.Failed, count)
+	}
+
+	result := &BidirResult{}
+	var forwardSamples, reverseSamples []probe.Sample
+	var pairedForward, pairedReverse []float64
+	for seq := 0; seq < count; seq++ {
+		var f, r *float64
+		if series.Forward[seq] > 0 {
+			ms := float64(series.Forward[seq]) / 1e6
+			f = &ms
+			forwardSamples = append(forwardSamples, probe.Sample{Seq: seq + 1, RTT: series.Forward[seq],
+				Sent: series.Sent[seq].UnixNano()})
+		}
+		if series.Reverse[seq] > 0 {
+			ms := float64(series.Reverse[seq]) / 1e6
+			r = &ms
+			reverseSamples = append(reverseSamples, probe.Sample{Seq: seq + 1, RTT: series.Reverse[seq]})
+		}
+		if f != nil && r != nil {
+			pairedForward = append(pairedForward, *f)
+			pairedReverse = append(pairedReverse, *r)
+		}
+		result.ForwardRTTs = append(result.ForwardRTTs, f)
+		result.ReverseRTTs = append(result.ReverseRTTs, r)
+	}
+	local, remote := prober.Local().String(), prober.Remote().String()
+	if len(forwardSamples) > 0 {
+		result.Forward = newResult(local, remote, forwardSamples)
+		loss := 100 * float64(count-len(forwardSamples)) / float64(count)
+		result.Forward.Loss = &loss
+	}
+	if len(reverseSamples) > 0 {
+		result.Reverse = newResult(remote, local, reverseSamples)
+		loss := 100 * float64(count-len(reverseSamples)) / float64(count)
+		result.Reverse.Loss = &loss
+	}
+	if len(pairedForward) > 1 {
+		result.Correlation = correlation(pairedForward, pairedReverse)
+	}
+	return result
+}
+
+func printBidir(result *BidirResult) {
+	fmt.Println("\nBidirectional RTT:")
+	for _, direction := range []struct {
+		label  string
+		result *probe.Result
+	}{{"Forward (client probes)", result.Forward}, {"Reverse (responder probes)", result.Reverse}} {
+		if direction.result == nil {
+			fmt.Printf("\t%s - no replies\n", direction.label)
+			continue
+		}
+		fmt.Printf("\t%s - %.3fms (min %.3fms, max %.3fms, loss %.1f%%)\n", direction.label,
+			direction.result.RTTAvg, direction.result.RTTMin, direction.result.RTTMax, *direction.result.Loss)
+	}
+	if result.Forward != nil && result.Reverse != nil {
+		fmt.Printf("\tDifference - %+.3fms\n", result.Forward.RTTAvg-result.Reverse.RTTAvg)
+	}
+	if result.Correlation != nil {
+		fmt.Printf("Correlation of the aligned series: %+.2f\n", *result.Correlation)
+	}
+}
+
+// Jitter buffer analysis of -voip, delays are in ms. The delay variation is that of the RTT over
+// its minimum, which bounds the one-way variation a receiver's jitter buffer has to absorb.
+type VoipResult struct {
+	Sent        int      `json:"sent"`
+	Replies     int      `json:"replies"`
+	Loss        float64  `json:"loss"`
+	MissedSlots int      `json:"missed_slots"`
+	PDV50       float64  `json:"pdv_p50"`
+	PDV95       float64  `json:"pdv_p95"`
+	PDV99       float64  `json:"pdv_p99"`
+	TargetLoss  float64  `json:"target_loss"`
+	BufferMs    *float64 `json:"jitter_buffer,omitempty"`
+}
+
+// Probes every VOIP_INTERVAL for duration like a voice stream. Probes are sequential, so a reply
+// slower than the interval delays the next probe and its slot is counted as missed.
+func runVoip(prober *probe.Prober, duration time.Duration, targetLoss float64, verbose bool) *VoipResult {
+	var rtts []int64
+	result := &VoipResult{TargetLoss: targetLoss}
+	start := time.Now()
+	for slot := 0; ; slot++ {
+		next := start.Add(time.Duration(slot) * VOIP_INTERVAL)
+		if next.Sub(start) >= duration {
+			break
+		}
+		if wait := time.Until(next); wait > 0 {
+			time.Sleep(wait)
+		} else if wait < -VOIP_INTERVAL {
+			result.MissedSlots += 1
+			continue
+		}
+
+		result.Sent += 1
+		reply, err := exchange(prober)
+		if err != nil {
+			if verbose {
+				progressf("Probe %d: %v\n", result.Sent, err)
+			}
+			continue
+		}
+		rtts = append(rtts, int64(reply.RTT()))
+	}
+
+	result.Replies = len(rtts)
+	result.Loss = 100 * float64(result.Sent-result.Replies) / float64(result.Sent)
+	if len(rtts) == 0 {
+		return result
+	}
+	min, _ := probe.MinMax(rtts)
+	pdv := make([]int64, len(rtts))
+	for i, rtt := range rtts {
+		pdv[i] = rtt - min
+	}
+	sort.Slice(pdv, func(i, j int) bool { return pdv[i] < pdv[j] })
+	result.PDV50 = float64(probe.Percentile(pdv, 50)) / 1e6
+	result.PDV95 = float64(probe.Percentile(pdv, 95)) / 1e6
+	result.PDV99 = float64(probe.Percentile(pdv, 99)) / 1e6
+
+	// Packets delayed beyond the buffer are dropped, which is only affordable on top of the
+	// network's own loss up to the target
+	late := int((targetLoss - result.Loss) / 100 * float64(result.Sent))
+	if late >= 0 {
+		if late >= len(pdv) {
+			late = len(pdv) - 1
+		}
+		buffer := float64(pdv[len(pdv)-1-late]) / 1e6
+		result.BufferMs = &buffer
+	}
+	return result
+}
+
+func printVoip(result *VoipResult) {
+	fmt.Printf("\nVoIP pattern, one probe every %s:\n", VOIP_INTERVAL)
+	fmt.Printf("\tSent - %d (%d slots missed waiting for replies)\n", result.Sent, result.MissedSlots)
+	fmt.Printf("\tLoss - %.1f%%\n", result.Loss)
+	if result.Replies == 0 {
+		fmt.Println("\tDelay variation - n/a")
+		return
+	}
+	fmt.Printf("\tDelay variation - p50 %.3fms, p95 %.3fms, p99 %.3fms\n", result.PDV50, result.PDV95, result.PDV99)
+	if result.BufferMs != nil {
+		fmt.Printf("\tJitter buffer for %.1f%% loss - %.1fms\n", result.TargetLoss, *result.BufferMs)
+	} else {
+		fmt.Printf("\tJitter buffer - n/a, the network loss alone exceeds the %.1f%% target\n", result.TargetLoss)
+	}
+}
+
+// Writes the path the prober uses for -load-path in a later run
+func savePath(filename string, entry *sciond.PathReplyEntry) error {
+	out, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, out, 0644)
+}
+
+// Reads a path written by -save-path, which must lead from local to remote. An expired path is
+// only warned about, routers may still forward it and a pinned path is what was asked for.
+func loadPath(filename string, local *snet.Addr, remote *snet.Addr) (*sciond.PathReplyEntry, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	entry := &sciond.PathReplyEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("Error, %s is not a saved path: %v", filename, err)
+	}
+	if entry.Path == nil || len(entry.Path.FwdPath) == 0 {
+		return nil, fmt.Errorf("Error, %s holds no path", filename)
+	}
+	if !entry.Path.SrcIA().Eq(local.IA) || !entry.Path.DstIA().Eq(remote.IA) {
+		return nil, fmt.Errorf("Error, path in %s leads from %s to %s, not from %s to %s", filename,
+			entry.Path.SrcIA(), entry.Path.DstIA(), local.IA, remote.IA)
+	}
+	if entry.Path.ExpTime != 0 && time.Now().After(entry.Path.Expiry()) {
+		log.Printf("Warning, path in %s expired at %s", filename, formatExpiry(entry.Path.Expiry()))
+	}
+	return entry, nil
+}
+
+// Paths resolved by earlier runs with -path-cache, keyed by pathCacheKey
+type pathCache map[string]*sciond.PathReplyEntry
+
+// Identifies the paths between two ASes chosen with the same path selection flags
+func pathCacheKey(local *snet.Addr, remote *snet.Addr, preferISD uint, avoidISD uint, maxHops int,
+	policy string) string {
+	return fmt.Sprintf("%s %s prefer-isd=%d avoid-isd=%d max-hops=%d path-policy=%q", local.IA, remote.IA,
+		preferISD, avoidISD, maxHops, policy)
+}
+
+// Reads the path cache, a missing file is an empty cache
+func loadPathCache(filename string) (pathCache, error) {
+	cache := make(pathCache)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("Error, %s is not a path cache: %v", filename, err)
+	}
+	return cache, nil
+}
+
+// Returns the cached path for key, unless it expired or there is none
+func (c pathCache) lookup(key string, now time.Time) *sciond.PathReplyEntry {
+	entry := c[key]
+	if entry == nil || entry.Path == nil || len(entry.Path.FwdPath) == 0 || !now.Before(entry.Path.Expiry()) {
+		return nil
+	}
+	return entry
+}
+
+// Stores the path for key and writes the cache without the expired paths. The file is replaced
+// in one step, so runs sharing the cache never read half of it.
+func (c pathCache) save(filename string, key string, entry *sciond.PathReplyEntry, now time.Time) error {
+	c[key] = entry
+	for k := range c {
+		if c.lookup(k, now) == nil {
+			delete(c, k)
+		}
+	}
+	out, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	if err := ioutil.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func formatExpiry(expiry time.Time) string {
+	if expiry.IsZero() {
+		return ""
+	}
+	return expiry.UTC().Format(time.RFC3339)
+}
+
+// Sends probes probes over every path to the destination, returning the paths ranked by
+// average RTT with the paths that never replied last. The paths are probed in the order of their
+// description, so that ties between them are broken the same way in every run.
+func probeAllPaths(prober *probe.Prober, probes int, verbose bool) []*probe.PathResult {
+	var options []*spathmeta.AppPath
+	for _, option := range prober.Paths() {
+		options = append(options, option)
+	}
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Entry.Path.String() < options[j].Entry.Path.String()
+	})
+
+	var results []*probe.PathResult
+	for _, option := range options {
+		check(prober.UsePath(option.Entry))
+		var rtts []int64
+		for i := 0; i < probes; i++ {
+			reply, err := exchange(prober)
+			if err != nil {
+				if verbose {
+					progressf("Path %s, probe %d: %v\n", option.Entry.Path, i+1, err)
+				}
+				continue
+			}
+			rtts = append(rtts, int64(reply.RTT()))
+		}
+
+		result := &probe.PathResult{
+			Path:    Anon.text(option.Entry.Path.String()),
+			Sent:    probes,
+			Samples: len(rtts),
+			Loss:    100 * float64(probes-len(rtts)) / float64(probes),
+			Entry:   option.Entry,
+		}
+		if len(rtts) > 0 {
+			mean, _ := probe.MeanAndStdErr(rtts)
+			avg := mean / 1e6
+			result.RTTAvg = &avg
+		}
+		results = append(results, result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].RTTAvg == nil || results[j].RTTAvg == nil {
+			return results[j].RTTAvg == nil && results[i].RTTAvg != nil
+		}
+		return *results[i].RTTAvg < *results[j].RTTAvg
+	})
+	return results
+}
+
+// Moves the path preferred by policy among those within tolerance ms of the fastest to the front
+// of the ranking, returning what decided the choice
+func breakTie(results []*probe.PathResult, tolerance float64, policy string) string {
+	if results[0].RTTAvg == nil {
+		return "no path replied"
+	}
+	tied := 1
+	for tied < len(results) && results[tied].RTTAvg != nil && *results[tied].RTTAvg-*results[0].RTTAvg <= tolerance {
+		tied += 1
+	}
+	if tied == 1 {
+		return "lowest RTT"
+	}
+
+	// The ranking is stable, so for equal criteria the faster path stays ahead
+	best := 0
+	for i := 1; i < tied; i++ {
+		switch policy {
+		case TIE_HOPS:
+			if len(results[i].Entry.Path.Interfaces) < len(results[best].Entry.Path.Interfaces) {
+				best = i
+			}
+		case TIE_MTU:
+			if results[i].Entry.Path.Mtu > results[best].Entry.Path.Mtu {
+				best = i
+			}
+		case TIE_FIRST:
+			if results[i].Path < results[best].Path {
+				best = i
+			}
+		}
+	}
+	winner := results[best]
+	copy(results[1:best+1], results[:best])
+	results[0] = winner
+	return fmt.Sprintf("%d paths within %.3fms, chose by %s", tied, tolerance, policy)
+}
+
+func printPathRanking(results []*probe.PathResult) {
+	fmt.Println("\nPaths ranked by average RTT:")
+	for i, r := range results {
+		if r.RTTAvg != nil {
+			fmt.Printf("\t%2d. %9.3fms %5.1f%% loss  %s\n", i+1, *r.RTTAvg, r.Loss, r.Path)
+		} else {
+			fmt.Printf("\t  - %11s %5.1f%% loss  %s\n", "n/a", r.Loss, r.Path)
+		}
+	}
+}
+
+// Reads a TOML-style config file of "key = value" lines, ignoring blank lines and # comments
+func readConfigFile(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	line_num := 0
+	for scanner.Scan() {
+		line_num += 1
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Error, %s:%d is not of the form key = value", filename, line_num)
+		}
+		key := strings.TrimSpace(parts[0])
+		if alias, ok := CONFIG_ALIASES[key]; ok {
+			key = alias
+		}
+		value := strings.Trim(strings.TrimSpace(parts[1]), "\"'")
+		settings[key] = value
+	}
+	return settings, scanner.Err()
+}
+
+// Applies config file settings to every flag not given on the command line, returning the flags it set
+func applyConfig(settings map[string]string) ([]string, error) {
+	fromCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		fromCommandLine[f.Name] = true
+	})
+
+	var applied []string
+	for key, value := range settings {
+		if flag.Lookup(key) == nil || key == "config" {
+			return nil, fmt.Errorf("Error, unknown setting in config file: %s", key)
+		}
+		if fromCommandLine[key] {
+			continue
+		}
+		if err := flag.Set(key, value); err != nil {
+			return nil, fmt.Errorf("Error, invalid value %q for %s in config file: %v", value, key, err)
+		}
+		applied = append(applied, key)
+	}
+	return applied, nil
+}
+
+// Quotes value for sh if it contains anything but safe characters
+func shellQuote(value string) string {
+	const safe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,:/=+@%[]"
+	if len(value) > 0 && strings.Trim(value, safe) == "" {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// Command line that reproduces the run: every flag that was set on the command line or by the
+// config file, in alphabetical order, with the values of the redacted flags hidden. The config
+// file itself is left out, its settings are already included.
+func normalizedCmdline(redact []string) string {
+	args := []string{"controlplane_client"}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		value := f.Value.String()
+		for _, name := range redact {
+			if name == f.Name {
+				value = "REDACTED"
+			}
+		}
+		args = append(args, "-"+f.Name+"="+shellQuote(value))
+	})
+	return strings.Join(args, " ")
+}
+
+// Prints the value of every flag after the config file was applied, as a JSON object. Values of
+// the redacted flags are hidden if set.
+func dumpConfig(redact []string) error {
+	settings := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		for _, name := range redact {
+			if name == f.Name && len(value) > 0 {
+				value = "REDACTED"
+			}
+		}
+		settings[f.Name] = value
+	})
+	out, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// Reports whether a flag was given on the command line or in the config file
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Back-off before retrying a failed probe, jittered uniformly within +-50% of delay
+func jitteredDelay(delay time.Duration) time.Duration {
+	return time.Duration(float64(delay) * (0.5 + randomFloat64()))
+}
+
+// Next time after now at the UTC time of day of clock, so that hosts in any time zone agree on it
+func nextTimeOfDay(clock time.Time, now time.Time) time.Time {
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// Mean and standard deviation (in ns) of the last window samples
+func windowSpread(samples []probe.Sample, window int) (float64, float64) {
+	mean, stderr := probe.MeanAndStdErr(probe.RTTs(samples[len(samples)-window:]))
+	return mean, stderr * math.Sqrt(float64(window))
+}
+
+// One minute load average of the local host, read from /proc/loadavg (Linux only)
+func readLoadAvg() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("Error, /proc/loadavg is empty")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// Pearson correlation coefficient of x and y, nil if either does not vary
+func correlation(x []float64, y []float64) *float64 {
+	n := float64(len(x))
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range x {
+		cov += (x[i] - meanX) * (y[i] - meanY)
+		varX += (x[i] - meanX) * (x[i] - meanX)
+		varY += (y[i] - meanY) * (y[i] - meanY)
+	}
+	if varX == 0 || varY == 0 {
+		return nil
+	}
+	r := cov / math.Sqrt(varX*varY)
+	return &r
+}
+
+// Send time of a probe since the start of the run and whether it timed out
+type probeOutcome struct {
+	sent time.Duration
+	lost bool
+}
+
+// Heuristic for a token bucket limiting the replies. Probes sent faster than it refills are lost
+// whenever it runs empty, so bursts of losses start at a regular period, unlike congestion losses.
+// Returns nil unless there are at least 4 bursts whose start times vary by less than 20% of the
+// period, or if (almost) every probe is lost.
+func detectRateLimit(outcomes []probeOutcome) *probe.RateLimit {
+	var starts []time.Duration
+	lost := 0
+	for i, o := range outcomes {
+		if !o.lost {
+			continue
+		}
+		lost += 1
+		if i == 0 || !outcomes[i-1].lost {
+			starts = append(starts, o.sent)
+		}
+	}
+	if len(starts) < 4 || lost > len(outcomes)*9/10 {
+		return nil
+	}
+
+	gaps := make([]int64, len(starts)-1)
+	for i := range gaps {
+		gaps[i] = int64(starts[i+1] - starts[i])
+	}
+	mean, stderr := probe.MeanAndStdErr(gaps)
+	stddev := stderr * math.Sqrt(float64(len(gaps)))
+	if mean <= 0 || stddev/mean >= 0.2 {
+		return nil
+	}
+	elapsed := (outcomes[len(outcomes)-1].sent - outcomes[0].sent).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+	return &probe.RateLimit{
+		Period: mean / 1e9,
+		Rate:   float64(len(outcomes)-lost) / elapsed,
+	}
+}
+
+// Mean of the RTTs (in ns) weighted by recency: a sample sent halfLife before the last one counts
+// half as much as the last one, one sent two half-lives before a quarter, and so on
+func decayedMean(samples []probe.Sample, halfLife time.Duration) float64 {
+	var last int64
+	for _, s := range samples {
+		if s.Sent > last {
+			last = s.Sent
+		}
+	}
+	var sum, weights float64
+	for _, s := range samples {
+		weight := math.Exp2(-float64(last-s.Sent) / float64(halfLife))
+		sum += weight * float64(s.RTT)
+		weights += weight
+	}
+	return sum / weights
+}
+
+// Mean of the samples (in ns) without the lowest and highest pct percent of them, rounded down to
+// whole samples. At least one sample remains as long as pct is below 50.
+func trimmedMean(samples []int64, pct float64) float64 {
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	cut := int(float64(len(sorted)) * pct / 100)
+	mean, _ := probe.MeanAndStdErr(sorted[cut : len(sorted)-cut])
+	return mean
+}
+
+// Adds a sample to a reservoir holding at most capacity samples (Algorithm R), where seen is the
+// number of samples offered before this one. Once full, every sample seen so far is kept with equal
+// probability, so the distribution is preserved but rare extremes may be evicted.
+func addToReservoir(samples []probe.Sample, s probe.Sample, seen int, capacity int) []probe.Sample {
+	if len(samples) < capacity {
+		return append(samples, s)
+	}
+	if j := randomIntn(seen + 1); j < capacity {
+		samples[j] = s
+	}
+	return samples
+}
+
+// Computes the summary statistics of the RTT samples with the addresses anonymized, see
+// probe.NewResult
+func newResult(source string, destination string, samples []probe.Sample) *probe.Result {
+	return probe.NewResult(Anon.text(source), Anon.text(destination), samples)
+}
+
+// Summary of one -on-change window of probes, loss is in percent
+func newWindowResult(source string, destination string, samples []probe.Sample, probes int) *probe.Result {
+	result := &probe.Result{Source: Anon.text(source), Destination: Anon.text(destination)}
+	if len(samples) > 0 {
+		result = newResult(source, destination, samples)
+	}
+	loss := 100 * float64(probes-len(samples)) / float64(probes)
+	result.Loss = &loss
+	return result
+}
+
+func lossLevel(loss float64) int {
+	level := 0
+	for _, boundary := range LOSS_BOUNDARIES {
+		if loss >= boundary {
+			level += 1
+		}
+	}
+	return level
+}
+
+// Decides whether a window summary differs enough from the last emitted one to be printed
+type changeFilter struct {
+	threshold float64 // In percent of the last emitted average RTT
+	emitted   bool
+	lastAvg   float64
+	lastLevel int
+}
+
+func (f *changeFilter) changed(result *probe.Result) bool {
+	level := lossLevel(*result.Loss)
+	change := !f.emitted || level != f.lastLevel
+	// A window without replies has no average, its loss level is what matters
+	if !change && result.Samples > 0 && f.lastAvg > 0 {
+		change = 100*math.Abs(result.RTTAvg-f.lastAvg)/f.lastAvg > f.threshold
+	}
+	if !change {
+		return false
+	}
+	f.emitted = true
+	f.lastLevel = level
+	if result.Samples > 0 {
+		f.lastAvg = result.RTTAvg
+	}
+	return true
+}
+
+func printChange(result *probe.Result, first int, last int, jsonOutput bool) {
+	if jsonOutput {
+		check(printJSON(result))
+	} else if result.Samples > 0 {
+		fmt.Printf("Probes %d-%d: RTT %.3fms, loss %.1f%%\n", first, last, result.RTTAvg, *result.Loss)
+	} else {
+		fmt.Printf("Probes %d-%d: no replies, loss %.1f%%\n", first, last, *result.Loss)
+	}
+}
+
+// Lines of -ping-compat, in the format of Linux iputils ping so that scripts parsing its output
+// work unchanged: the SCION address takes the place of the host name and its host address that of
+// the IP, the probe's packet size that of the ICMP bytes and the probe number that of icmp_seq.
+// There is no TTL in SCION, so the field is left out as ping does for replies without one.
+func pingHeader(remote *snet.Addr, size int) string {
+	return Anon.text(fmt.Sprintf("PING %s,[%s] (%s) %d bytes of data.", remote.IA, remote.Host, remote.Host, size))
+}
+
+func pingReply(from string, bytes int, seq int, rtt time.Duration) string {
+	return fmt.Sprintf("%d bytes from %s: icmp_seq=%d time=%.3f ms", bytes, Anon.text(from), seq,
+		float64(rtt)/1e6)
+}
+
+// With -count 0 the RTT statistics are those of the sampled replies
+func printPingSummary(remote *snet.Addr, sent int, received int, samples []probe.Sample,
+	elapsed time.Duration) {
+	fmt.Println(Anon.text(fmt.Sprintf("\n--- %s,[%s] ping statistics ---", remote.IA, remote.Host)))
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(sent-received) / float64(sent)
+	}
+	// Six significant digits like C's %g
+	fmt.Printf("%d packets transmitted, %d received, %s%% packet loss, time %dms\n", sent, received,
+		strconv.FormatFloat(loss, 'g', 6, 64), elapsed.Nanoseconds()/1e6)
+	if len(samples) == 0 {
+		return
+	}
+	// mdev is the population standard deviation, as ping computes it
+	rtts := probe.RTTs(samples)
+	min, max := probe.MinMax(rtts)
+	var sum, squares float64
+	for _, rtt := range rtts {
+		sum += float64(rtt)
+		squares += float64(rtt) * float64(rtt)
+	}
+	mean := sum / float64(len(rtts))
+	mdev := math.Sqrt(math.Max(squares/float64(len(rtts))-mean*mean, 0))
+	fmt.Printf("rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", float64(min)/1e6, mean/1e6, float64(max)/1e6,
+		mdev/1e6)
+}
+
+// Fixed-width status of -oneline, avg is in ms and ignored without replies
+func onelineStatus(destination string, avg float64, loss float64, replies int) string {
+	destination = Anon.text(destination)
+	if replies == 0 {
+		return fmt.Sprintf("dst=%s avg=%10s loss=%5.1f%% n=%-6d", destination, "n/a", loss, replies)
+	}
+	return fmt.Sprintf("dst=%s avg=%8.3fms loss=%5.1f%% n=%-6d", destination, avg, loss, replies)
+}
+
+// Levels of the sparkline, from the fastest to the slowest RTT
+var SPARK_LEVELS = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline of the RTTs of the last width samples, scaled from their min to max and padded to width
+func sparkline(samples []probe.Sample, width int) string {
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	if len(samples) == 0 {
+		return strings.Repeat(" ", width)
+	}
+	min, max := samples[0].RTT, samples[0].RTT
+	for _, s := range samples {
+		if s.RTT < min {
+			min = s.RTT
+		}
+		if s.RTT > max {
+			max = s.RTT
+		}
+	}
+	line := make([]rune, 0, width)
+	for _, s := range samples {
+		level := 0
+		if max > min {
+			level = int((s.RTT - min) * int64(len(SPARK_LEVELS)-1) / (max - min))
+		}
+		line = append(line, SPARK_LEVELS[level])
+	}
+	return string(line) + strings.Repeat(" ", width-len(line))
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func printSummary(result *probe.Result) {
+	fmt.Printf("\nSource: %s\nDestination: %s\n", result.Source, result.Destination)
+	fmt.Println("Mode:", result.Mode)
+	fmt.Println("Bytes sent:", result.BytesSent)
+	if len(result.Clock) > 0 {
+		fmt.Println("Clock:", result.Clock)
+	}
+	fmt.Println("Duration:", time.Duration(result.Duration*1e6))
+	if result.ToBorder {
+		fmt.Println("Measured to: border router of the destination AS")
+	}
+	if result.SizeLimit != nil {
+		fmt.Printf("Size: clamped from %d to %d bytes by the path MTU of %d bytes\n", result.SizeLimit.Requested,
+			result.SizeLimit.Effective, result.SizeLimit.MTU)
+	}
+	if result.Approximate {
+		fmt.Printf("Statistics are estimated from a random subset of the %d samples\n", result.Samples)
+	}
+	if result.ConstantRTT {
+		fmt.Printf("Warning, implausibly constant RTT: all %d samples lie within %s, the replies may come from\n",
+			result.Samples, probe.CONSTANT_RTT_SPREAD)
+		fmt.Println("\ta local loopback, a caching middlebox or a synthetic responder rather than the path")
+	}
+	fmt.Println("Time estimates:")
+	if result.RTTAvgUnweighted != nil {
+		fmt.Printf("\tRTT (recency-weighted, half-life %s) - %.3fms\n",
+			time.Duration(result.DecayHalfLife*float64(time.Second)), result.RTTAvg)
+		fmt.Printf("\tUnweighted RTT - %.3fms\n", *result.RTTAvgUnweighted)
+	}
+	if result.RTTAvgUnweighted != nil && result.CI95 != nil {
+		fmt.Printf("\t95%% CI of the unweighted RTT - ± %.3fms\n", *result.CI95)
+		fmt.Printf("\tStd. error - %.3fms\n", *result.StdErr)
+	} else if result.CI95 != nil {
+		fmt.Printf("\tRTT - %.3f ± %.3fms (95%% CI)\n", result.RTTAvg, *result.CI95)
+		fmt.Printf("\tStd. error - %.3fms\n", *result.StdErr)
+	} else if result.RTTAvgUnweighted != nil {
+		fmt.Println("\tStd. error - n/a")
+	} else {
+		fmt.Printf("\tRTT - %.3fms ± n/a\n", result.RTTAvg)
+		fmt.Println("\tStd. error - n/a")
+	}
+	fmt.Printf("\tMin - %.3fms (probe %d)\n", result.RTTMin, result.RTTMinSeq)
+	fmt.Printf("\tMax - %.3fms (probe %d)\n", result.RTTMax, result.RTTMaxSeq)
+	fmt.Printf("\tRange - %.3fms\n", result.Range)
+	if result.RTTTrimmed != nil {
+		fmt.Printf("\tTrimmed mean - %.3fms (%g%% of the samples dropped at each end)\n", *result.RTTTrimmed,
+			result.Trim)
+	}
+	if result.Latency != nil {
+		fmt.Printf("\tLatency - %.3fms\n", *result.Latency)
+	}
+	if result.SmallRTTAvg != nil {
+		fmt.Printf("\tRTT of minimal probe - %.3fms\n", *result.SmallRTTAvg)
+		fmt.Printf("\tRTT of %d byte probe - %.3fms\n", result.Size, result.RTTAvg)
+	}
+	if result.PerByte != nil {
+		fmt.Printf("\tTransmission delay - %.3fus per byte (round trip)\n", *result.PerByte)
+	}
+	if result.RTTTrend != nil {
+		fmt.Printf("\tTrend - %+.3fms per 100 probes\n", *result.RTTTrend)
+	} else {
+		fmt.Println("\tTrend - n/a")
+	}
+	if len(result.Responders) > 0 {
+		fmt.Printf("Responders (%d, switched %d times):\n", len(result.Responders), *result.Switches)
+		for _, r := range result.Responders {
+			fmt.Printf("\t%s - %d replies (%.1f%%), RTT %.3fms\n", r.Responder, r.Replies, r.Share, r.RTTAvg)
+		}
+	}
+	if result.Overhead != nil {
+		fmt.Printf("\tLocal overhead - %.3fms (calibrated)\n", *result.Overhead)
+	}
+	if result.Adjusted != nil {
+		fmt.Printf("\tRTT without overhead - %.3fms (min %.3fms, max %.3fms)\n", result.Adjusted.RTTAvg,
+			result.Adjusted.RTTMin, result.Adjusted.RTTMax)
+	}
+	if result.Late != nil {
+		fmt.Printf("Late replies: %d (RTT min %.3fms, median %.3fms, max %.3fms)\n", result.Late.Replies,
+			result.Late.RTTMin, result.Late.RTTMedian, result.Late.RTTMax)
+	}
+	if result.Corrupted != nil {
+		fmt.Printf("Corrupted replies: %d (payload checksum mismatch)\n", *result.Corrupted)
+	}
+	if result.PoissonRate != nil {
+		fmt.Printf("Poisson probing: %.3f probes/s achieved\n", *result.PoissonRate)
+		if result.Seed != nil {
+			fmt.Printf("Seed: %d\n", *result.Seed)
+		}
+	}
+	if len(result.Extension) > 0 {
+		fmt.Printf("Extension: echo requests carried the %s hop-by-hop extension, loss %.1f%%\n", result.Extension,
+			*result.Loss)
+	}
+	if result.LoadCorrelation != nil {
+		fmt.Printf("Correlation of RTT with local load average: %+.2f\n", *result.LoadCorrelation)
+		if *result.LoadCorrelation > 0.5 {
+			fmt.Println("\tRTT spikes follow the local load, they are likely caused by this host, not the network")
+		}
+	}
+	if result.MeasuredWindow != nil {
+		fmt.Printf("Measured window: %s to %s (scheduled %s, %d warm-up probes)\n", result.MeasuredWindow.Start,
+			result.MeasuredWindow.End, result.MeasuredWindow.Scheduled, result.MeasuredWindow.Warmup)
+	}
+	if result.Stability != nil {
+		if result.Stability.Stable {
+			fmt.Printf("Stabilized after %d probes: RTT %.3fms, std. dev. %.3fms over the last %d\n",
+				result.Stability.Probes, result.Stability.RTTAvg, result.Stability.StdDev, result.Stability.Window)
+		} else {
+			fmt.Printf("Did not stabilize within %d probes: std. dev. %.3fms over the last %d\n",
+				result.Stability.Probes, result.Stability.StdDev, result.Stability.Window)
+		}
+	}
+	if result.RateLimit != nil {
+		fmt.Printf("Possible SCMP rate limiting: losses recur every %.1fs, about %.1f replies/s get through\n",
+			result.RateLimit.Period, result.RateLimit.Rate)
+	}
+	if result.SkippedTicks != nil {
+		fmt.Printf("Paced probing: %d ticks skipped while a probe was outstanding\n", *result.SkippedTicks)
+	}
+	for _, breach := range result.Breaches {
+		fmt.Println("THRESHOLD EXCEEDED:", breach)
+	}
+	if result.IdCollisions != nil && *result.IdCollisions > 0 {
+		fmt.Printf("Id collisions: %d echo request Ids were drawn more than once, widen -id-range\n",
+			*result.IdCollisions)
+	}
+	for _, p := range result.ReplyPaths {
+		fmt.Printf("\nReply path %s (%d samples):", p.Fingerprint, p.Stats.Samples)
+		printSummary(p.Stats)
+	}
+}
+
+// Prints an ASCII histogram of the RTT samples (in ns) over bins equal-width buckets from min to max
+func printHistogram(samples []int64, bins int) {
+	min, max := probe.MinMax(samples)
+	width := float64(max-min) / float64(bins)
+
+	counts := make([]int, bins)
+	most := 0
+	for _, s := range samples {
+		bin := bins - 1
+		if width > 0 {
+			bin = int(float64(s-min) / width)
+		}
+		if bin >= bins {
+			bin = bins - 1
+		}
+		counts[bin] += 1
+		if counts[bin] > most {
+			most = counts[bin]
+		}
+	}
+
+	fmt.Println("RTT histogram:")
+	for i, c := range counts {
+		low := float64(min) + float64(i)*width
+		bar := strings.Repeat("#", c*HIST_WIDTH/most)
+		fmt.Printf("\t%9.3f - %9.3fms |%-*s %d\n", low/1e6, (low+width)/1e6, HIST_WIDTH, bar, c)
+	}
+}
+
+func printJSON(result *probe.Result) error {
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// Statistics of one destination over the runs read by -aggregate
+type aggregateSummary struct {
+	destination string
+	runs        int
+	samples     int
+	min         float64
+	max         float64
+	rttSum      float64 // Sum of each run's average RTT weighted by its samples
+	losses      []int64 // In hundredths of a percent, in run order, for the trend
+}
+
+// Combines the JSON results of earlier runs in dir per destination. Runs are ordered by the
+// modification time of their file, files that are not results are skipped with a warning.
+func aggregate(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	summaries := make(map[string]*aggregateSummary)
+	var destinations []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		path := dir + string(os.PathSeparator) + file.Name()
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning, skipping %s: %v", path, err)
+			continue
+		}
+		result := &probe.Result{}
+		if err := json.Unmarshal(data, result); err != nil {
+			log.Printf("Warning, skipping %s: %v", path, err)
+			continue
+		}
+		if len(result.Destination) == 0 || result.Samples == 0 {
+			log.Printf("Warning, skipping %s: not a measurement result", path)
+			continue
+		}
+
+		summary, ok := summaries[result.Destination]
+		if !ok {
+			summary = &aggregateSummary{destination: result.Destination, min: result.RTTMin, max: result.RTTMax}
+			summaries[result.Destination] = summary
+			destinations = append(destinations, result.Destination)
+		}
+		summary.runs += 1
+		summary.samples += result.Samples
+		summary.rttSum += result.RTTAvg * float64(result.Samples)
+		summary.min = math.Min(summary.min, result.RTTMin)
+		summary.max = math.Max(summary.max, result.RTTMax)
+		// Results written before loss was reported count as lossless
+		if result.Loss != nil {
+			summary.losses = append(summary.losses, int64(*result.Loss*100))
+		} else {
+			summary.losses = append(summary.losses, 0)
+		}
+	}
+	if len(destinations) == 0 {
+		return fmt.Errorf("Error, no results found in %s", dir)
+	}
+
+	sort.Strings(destinations)
+	for _, destination := range destinations {
+		summary := summaries[destination]
+		var lossSum int64
+		for _, loss := range summary.losses {
+			lossSum += loss
+		}
+		fmt.Printf("\nDestination: %s\n", destination)
+		fmt.Printf("\tRuns - %d (%d samples)\n", summary.runs, summary.samples)
+		fmt.Printf("\tRTT - min %.3fms, avg %.3fms, max %.3fms\n",
+			summary.min, summary.rttSum/float64(summary.samples), summary.max)
+		fmt.Printf("\tLoss - avg %.1f%%, first run %.1f%%, last run %.1f%%\n",
+			float64(lossSum)/float64(len(summary.losses))/100, float64(summary.losses[0])/100,
+			float64(summary.losses[len(summary.losses)-1])/100)
+		if len(summary.losses) >= 3 {
+			fmt.Printf("\tLoss trend - %+.2f%% per run\n", probe.TrendSlope(summary.losses)/100)
+		} else {
+			fmt.Println("\tLoss trend - n/a")
+		}
+	}
+	return nil
+}
+
+// Fails with the path and the service to start if a socket the probes depend on is missing, which
+// the SCION libraries otherwise report as an obscure dial error
+func checkSockets(dispatcherPath string, sciondPath string) error {
+	sockets := []struct {
+		path    string
+		service string
+	}{
+		{dispatcherPath, "the dispatcher"},
+		{sciondPath, "sciond"},
+	}
+	for _, socket := range sockets {
+		info, err := os.Stat(socket.path)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Error, socket %s does not exist, is %s running? "+
+				"Start it (e.g. ./scion.sh start) or pass -skip-socket-check", socket.path, socket.service)
+		}
+		if err != nil {
+			return fmt.Errorf("Error, cannot access socket %s of %s: %v", socket.path, socket.service, err)
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("Error, %s is not a socket, expected %s to listen on it", socket.path, socket.service)
+		}
+	}
+	return nil
+}
+
+// Runs command through sh with the JSON result on its stdin. The command is run as given with the
+// privileges of this process, so it must never be built from untrusted input.
+func execWithResult(command string, result *probe.Result) error {
+	in, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error, -exec command %q failed: %v", command, err)
+	}
+	return nil
+}
+
+// DogStatsD separates tags with commas and fields with pipes, SCION addresses contain commas
+func statsdTag(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_").Replace(value)
+}
+
+// Sends the RTTs as StatsD timings (ms) and the rest as gauges in one UDP packet. With tags the
+// source and destination are attached DogStatsD style.
+func sendToStatsd(addr string, result *probe.Result, tags bool) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	suffix := ""
+	if tags {
+		suffix = fmt.Sprintf("|#src:%s,dst:%s,mode:%s", statsdTag(result.Source), statsdTag(result.Destination),
+			result.Mode)
+	}
+	lines := []string{
+		fmt.Sprintf("scion.rtt.avg:%.3f|ms%s", result.RTTAvg, suffix),
+		fmt.Sprintf("scion.rtt.min:%.3f|ms%s", result.RTTMin, suffix),
+		fmt.Sprintf("scion.rtt.max:%.3f|ms%s", result.RTTMax, suffix),
+		fmt.Sprintf("scion.samples:%d|g%s", result.Samples, suffix),
+	}
+	if result.CI95 != nil {
+		lines = append(lines, fmt.Sprintf("scion.rtt.ci95:%.3f|ms%s", *result.CI95, suffix))
+	}
+	if result.Loss != nil {
+		lines = append(lines, fmt.Sprintf("scion.loss:%.3f|g%s", *result.Loss, suffix))
+	}
+	_, err = conn.Write([]byte(strings.Join(lines, "\n")))
+	return err
+}
+
+// Describes every limit of -max-rtt and -max-loss (0 for no limit) the result exceeds
+func checkThresholds(result *probe.Result, maxRTT float64, maxLoss float64) []string {
+	var breaches []string
+	if maxRTT > 0 && result.RTTAvg > maxRTT {
+		breaches = append(breaches, fmt.Sprintf("RTT %.3fms exceeds %.3fms", result.RTTAvg, maxRTT))
+	}
+	if maxLoss > 0 && result.Loss != nil && *result.Loss > maxLoss {
+		breaches = append(breaches, fmt.Sprintf("loss %.1f%% exceeds %.1f%%", *result.Loss, maxLoss))
+	}
+	return breaches
+}
+
+// Parses an -slo of the form RTT/loss, e.g. 50ms/1%, into the RTT in ms and the loss in percent.
+// Either part may be empty for no limit on it.
+func parseSLO(spec string) (float64, float64, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 || len(parts[0])+len(parts[1]) == 0 {
+		return 0, 0, fmt.Errorf("Error, invalid SLO %q, expected RTT/loss such as 50ms/1%%", spec)
+	}
+	var rtt, loss float64
+	if len(parts[0]) > 0 {
+		d, err := time.ParseDuration(parts[0])
+		if err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("Error, invalid SLO RTT %q, expected a duration such as 50ms", parts[0])
+		}
+		rtt = float64(d) / 1e6
+	}
+	if len(parts[1]) > 0 {
+		var err error
+		loss, err = strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64)
+		if err != nil || loss <= 0 || loss > 100 {
+			return 0, 0, fmt.Errorf("Error, invalid SLO loss %q, expected a percentage such as 1%%", parts[1])
+		}
+	}
+	return rtt, loss, nil
+}
+
+func compareToSLO(result *probe.Result, rtt float64, loss float64) *probe.SLOReport {
+	report := &probe.SLOReport{RTT: rtt, Loss: loss}
+	if rtt > 0 {
+		margin := rtt - result.RTTAvg
+		report.RTTMargin = &margin
+	}
+	if loss > 0 && result.Loss != nil {
+		margin := loss - *result.Loss
+		report.LossMargin = &margin
+	}
+	return report
+}
+
+func printSLO(r *probe.SLOReport) {
+	fmt.Println("SLO:")
+	if r.RTTMargin != nil {
+		if *r.RTTMargin >= 0 {
+			fmt.Printf("\tRTT - within %.3fms, %.3fms of budget left\n", r.RTT, *r.RTTMargin)
+		} else {
+			fmt.Printf("\tRTT - over %.3fms by %.3fms\n", r.RTT, -*r.RTTMargin)
+		}
+	}
+	if r.LossMargin != nil {
+		if *r.LossMargin >= 0 {
+			fmt.Printf("\tLoss - within %.1f%%, %.1f points of budget left\n", r.Loss, *r.LossMargin)
+		} else {
+			fmt.Printf("\tLoss - over %.1f%% by %.1f points\n", r.Loss, -*r.LossMargin)
+		}
+	}
+	if r.Met() {
+		fmt.Println("\tMET")
+	} else {
+		fmt.Println("\tMISSED")
+	}
+}
+
+// Posts an annotation of the breaches to the Grafana HTTP API at baseURL, tagged with the source
+// and destination so that dashboards can filter them
+func annotateGrafana(baseURL string, token string, result *probe.Result) error {
+	annotation := map[string]interface{}{
+		"time": time.Now().UnixNano() / int64(time.Millisecond),
+		"tags": []string{"scion-latency", "src:" + result.Source, "dst:" + result.Destination},
+		"text": fmt.Sprintf("%s -> %s: %s", result.Source, result.Destination, strings.Join(result.Breaches, ", ")),
+	}
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(baseURL, "/")+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: GRAFANA_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Grafana answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Writes the summary as one key=value message to the local syslog, or to addr (host:port, UDP) if set
+func sendToSyslog(addr string, result *probe.Result) error {
+	var writer *syslog.Writer
+	var err error
+	if len(addr) > 0 {
+		writer, err = syslog.Dial("udp", addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "controlplane_client")
+	} else {
+		writer, err = syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "controlplane_client")
+	}
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	msg := fmt.Sprintf("src=%s dst=%s mode=%s samples=%d rtt_avg=%.3fms rtt_min=%.3fms rtt_max=%.3fms",
+		result.Source, result.Destination, result.Mode, result.Samples, result.RTTAvg, result.RTTMin, result.RTTMax)
+	if result.CI95 != nil {
+		msg += fmt.Sprintf(" ci95=%.3fms", *result.CI95)
+	}
+	return writer.Info(msg)
+}
+
+// Run before exiting on an error, so that buffered output is not lost
+var exitHooks []func()
+
+func runExitHooks() {
+	for _, hook := range exitHooks {
+		hook()
+	}
+}
+
+// Exits with code, after the exit hooks, which os.Exit would skip
+func exit(code int) {
+	runExitHooks()
+	os.Exit(code)
+}
+
+func check(e error) {
+	if e != nil {
+		runExitHooks()
+		log.Fatal(e)
+	}
+}
+
+func printUsage() {
+	fmt.Println("\nrandom_speedclient -s SourceSCIONAddress -d DestinationSCIONAddress")
+	fmt.Println("\tProvides speed estimates (RTT and latency) from source to desination")
+	fmt.Println("\tThe SCION address is specified as ISD-AS,[IP Address]:Port")
+	fmt.Println("\tIf source port unspecified, a random available one will be used.")
+	fmt.Println("\tLatency is estimated as RTT/2, which assumes a symmetric path, -no-oneway omits it")
+	fmt.Println("\tProbing gives up after count * -tries-per-sample probes, or -max-tries probes if given")
+	fmt.Println("\tSCMP echo requests are numbered from -seq-start, wrapping around from 65535 to 0")
+	fmt.Println("\tWith -count 0 probing continues until interrupted, then the summary is printed")
+	fmt.Println("\tWith -duration probing stops after that long, and RTTs per second are printed as CSV (or JSON)")
+	fmt.Printf("\tWith -count 0 and -on-change pct, every %d probes are summarized, but a summary is only\n", CHANGE_WINDOW)
+	fmt.Println("\t\tprinted if its RTT moved by more than pct percent from the last printed one or the loss")
+	fmt.Println("\t\tcrossed 5, 25 or 50 percent. The first is always printed, -json prints them as JSON objects,")
+	fmt.Println("\t\t-openmetrics is not supported, and the final summary, -syslog and -exec are unaffected")
+	fmt.Println("\tWith -oneline on a terminal the status is updated after every probe with a sparkline of the")
+	fmt.Println("\t\tlast -spark-width RTTs, scaled from the fastest to the slowest of them")
+	fmt.Println("\tWith -mode udp the RTT is measured with UDP packets echoed by dataplane_server at the destination")
+	fmt.Println("\tWith -to-border the RTT is measured to the destination AS's border router, not the host")
+	fmt.Printf("\tWith -voip a probe is sent every %s for -duration, probes are sequential, so the delay\n",
+		VOIP_INTERVAL)
+	fmt.Println("\t\tvariation is that of the RTT, and a reply slower than the interval skips the next slot")
+	fmt.Println("\tWith -poisson the times between probes are exponentially distributed (RFC 2330), so")
+	fmt.Println("\t\tprobes do not alias with periodic events; a reply slower than the gap delays the next probe")
+	fmt.Println("\t\tThe gaps are drawn from -seed, which the JSON result reports, so a run can be repeated")
+	fmt.Println("\tWith -pace probes are sent on the ticks of a clock, evenly spaced regardless of the RTT, and a")
+	fmt.Println("\t\ttick that passes while a probe is outstanding (including its timeout) is skipped and counted")
+	fmt.Println("\tWith -capture the probe packets are written to a pcapng file with nanosecond timestamps and")
+	fmt.Printf("\t\tinbound/outbound flags, link type %d (DLT_USER 0) holds whole SCION packets in -mode scmp,\n",
+		probe.LINKTYPE_SCION)
+	fmt.Printf("\t\tlink type %d (DLT_USER 1) only the UDP payloads in -mode udp\n", probe.LINKTYPE_UDP_PAYLOAD)
+	fmt.Println("\tWith -per-interface each router along the path is probed in turn, * marks one that never replied")
+	fmt.Println("\tWith -calibrate the local overhead is the median RTT of echo requests to the local host,")
+	fmt.Println("\t\twhich never leave it, so overhead on the network path is not included")
+	fmt.Println("\tWith -exec the command is run by sh with the JSON result on stdin, never pass untrusted input")
+	fmt.Println("\tWith -aggregate DIR the *.json results of earlier -json runs are summarized per destination")
+	fmt.Println("\tWith -path-policy only paths matching all of its comma separated terms are measured over:")
+	fmt.Println("\t\t+ISD/-ISD transits/avoids the ISD, +IA/-IA transits/avoids the AS, +IA>IA/-IA>IA does/does")
+	fmt.Println("\t\tnot traverse the ASes one after the other, hops<=N/hops>=N has at most/least N interface hops")
+	fmt.Println("\tWith -path-cache a path is reused until it expires by later runs to the same destination AS")
+	fmt.Println("\t\twith the same -prefer-isd, -avoid-isd, -max-hops and -path-policy")
+	fmt.Println("\tWith -both SCMP echo requests and UDP probes to dataplane_server alternate over the same path,")
+	fmt.Println("\t\ttheir paired difference is the time the responder host and application take to answer")
+	fmt.Printf("\tWith -clock %s (default) RTTs are immune to steps of the system clock, with -clock %s\n",
+		probe.CLOCK_MONOTONIC, probe.CLOCK_WALL)
+	fmt.Println("\t\tthey are differences of wall clock times, comparable with timestamps taken on other hosts")
+	fmt.Println("\tWith -until-stable probing stops as soon as the standard deviation of the last -stable-window")
+	fmt.Println("\t\tRTTs is below -stable-stddev, so noisy paths get more probes than clean ones")
+	fmt.Println("\tWith -decay the reported RTT is a recency-weighted average: a sample sent one half-life")
+	fmt.Println("\t\tbefore the last counts half as much, two half-lives a quarter; the CI is of the unweighted RTT")
+	fmt.Println("\tWith -binary the summary is written in a fixed 76 byte header, big-endian, followed by the")
+	fmt.Println("\t\tsource and destination as 16 bit length and bytes; the field layout is documented with")
+	fmt.Println("\t\tbinaryHeader in the probe package and read back by probe.DecodeBinary")
+	fmt.Println("\tWith -with-load the one minute load average is read from /proc/loadavg after every reply, it")
+	fmt.Println("\t\tonly changes every 5 seconds, so the correlation is only meaningful for longer runs")
+	fmt.Println("\tWith -split-by-path replies are grouped by the path they took, identified by a hash of its")
+	fmt.Println("\t\traw hop fields, and each group gets its own statistics after the overall ones")
+	fmt.Println("\tWith -chain the destinations are measured in order like the services of a request, each")
+	fmt.Println("\t\tsegment from this host, and reported with the running total of their RTTs")
+	fmt.Println("\tWith -slo the average RTT and the loss are compared to the objective, e.g. -slo 50ms/1%,")
+	fmt.Println("\t\teither half may be left empty; it sets the -max-rtt/-max-loss thresholds as well")
+	fmt.Println("\tWith -size-sweep every size gets count probes over the same path; the first size without")
+	fmt.Println("\t\treplies likely exceeds the path MTU, sizes that exceed the MTU sciond reports are not sent")
+	fmt.Println("\t\tif the larger sizes within that MTU all time out, a path MTU black hole is reported")
+	fmt.Println("\tWith -anonymize hosts, and with -anonymize-as the AS numbers, are replaced in the summary, JSON")
+	fmt.Println("\t\tand other outputs by keyed hashes that are the same throughout a run but differ between runs")
+	fmt.Println("\tWith -parquet the columns are seq, send_ts and recv_ts (ns since the epoch), rtt_ns and path_id,")
+	fmt.Println("\t\tthe fingerprint of the reply path; the JSON summary is in the file metadata under")
+	fmt.Printf("\t\t%s, it is missing if the run was interrupted\n", probe.PARQUET_SUMMARY_KEY)
+	fmt.Println("\tWith -rotate the -output file is named after the UTC start of its period, e.g. with -rotate 1h")
+	fmt.Println("\t\tprobes.csv.gz becomes probes-20060102T150000Z.csv.gz; a file is complete once the next one")
+	fmt.Println("\t\tis started, old files are left for log shippers to collect")
+	fmt.Println("\tWith -checksum a CRC32 of each reply's payload is compared with the request's; SCMP probes")
+	fmt.Println("\t\tonly carry a payload beyond their Id with -size")
+	fmt.Println("\tWith -measure-at the path is warmed up with -warmup probes just before the window, which then")
+	fmt.Println("\t\truns like -duration -measure-for; the time is UTC so that a fleet measures the same window")
+	fmt.Println("\tWith -bidir dataplane_server -bidir probes this client while being probed, both over the same")
+	fmt.Println("\t\tpath; the two RTT series are aligned by sequence number, the protocol is described with")
+	fmt.Printf("\t\tBIDIR_MAGIC in the source; both sides probe at most %d times, at least %s apart\n",
+		probe.BIDIR_MAX_COUNT, probe.BIDIR_MIN_INTERVAL)
+	fmt.Println("\tWith -ping-compat the output follows Linux ping: the SCION address stands in for the host,")
+	fmt.Println("\t\ticmp_seq is the probe number and the bytes are those of the probe packet; there is no ttl")
+	fmt.Println("\tWith -svc the echo requests go to a service address of the -d ISD-AS, e.g. -d 1-ff00:0:110")
+	fmt.Println("\t\t-svc CS, and the service instances that answered are listed with their share of replies")
+	fmt.Printf("\tWith -weights each destination scores (100 - loss%%) * %g / (%g + RTT in ms), and the batch the\n",
+		SCORE_RTT_SCALE, SCORE_RTT_SCALE)
+	fmt.Println("\t\tweighted mean over all runs; destinations missing from the file weigh 1")
+	fmt.Println("\tWith -openmetrics -exemplars the histogram is part of the summary printed on stdout once the")
+	fmt.Println("\t\trun ends; there is no endpoint to scrape, so feed it to a collector, e.g. a textfile exporter")
+	fmt.Println("\tWith -f the destinations listed in the file are measured one after the other, -runs times")
+	fmt.Println("\tOptions may also be set in a config file given with -config, command line flags take precedence")
+	fmt.Println("\tExample SCION address 1-1,[127.0.0.1]:42002")
+	fmt.Println("\tExit codes:")
+	fmt.Println("\t\t0 - measurement completed")
+	fmt.Println("\t\t1 - error, including giving up before enough replies were received")
+	fmt.Println("\t\t2 - invalid command line flags")
+	fmt.Printf("\t\t%d - no reply was received at all\n", EXIT_NO_REPLY)
+	fmt.Printf("\t\t%d - regression compared to -baseline\n", EXIT_REGRESSION)
+	fmt.Printf("\t\t%d - the -slo was missed\n\n", EXIT_SLO_MISSED)
+}
+
+func main() {
+	var (
+		sourceAddress string
+		destinationAddress string
+		chainList string
+		configFile string
+		count int
+		interval time.Duration
+		timeout time.Duration
+		retryDelay time.Duration
+		verbose bool
+		toBorder bool
+		mode string
+		showBind bool
+		maxBytes int64
+		showVersion bool
+		size int
+		ttfb bool
+		execCommand string
+		noOneway bool
+		useSyslog bool
+		statsdAddr string
+		maxRTT float64
+		sloSpec string
+		maxLoss float64
+		grafanaURL string
+		grafanaToken string
+		statsdTags bool
+		syslogAddr string
+		dscp int
+		strictSrc bool
+		looseId bool
+		interleaveAddress string
+		both bool
+		outputPath string
+		parquetPath string
+		rotate time.Duration
+		checksum bool
+		measureAt string
+		clampSize bool
+		bidir bool
+		pingCompat bool
+		svcName string
+		sourcePort int
+		weightsFile string
+		measureFor time.Duration
+		warmup int
+		outputFormat string
+		compress bool
+		pipePath string
+		allPaths bool
+		pinBest bool
+		tieBreak string
+		tieTolerance float64
+		absTimes bool
+		aggregateDir string
+		echoId uint64
+		idRangeSpec string
+		duration time.Duration
+		skipSocketCheck bool
+		destinationsFile string
+		runs int
+		shuffle bool
+		perInterface bool
+		sizeSweepSpec string
+		oneline bool
+		sparkWidth int
+		voip bool
+		voipLoss float64
+		maxHops int
+		pathPolicyExpr string
+		savePathFile string
+		loadPathFile string
+		pathCacheFile string
+		emitCmdline bool
+		redactFlags string
+		calibrateOverhead bool
+		subtractOverhead bool
+		baselineFile string
+		regressThreshold float64
+		seqStart uint
+		showConfig bool
+		trackResponders bool
+		anonymize bool
+		anonymizeAS bool
+		exemplars bool
+		sndbuf int
+		captureFile string
+		poissonRate float64
+		seed int64
+		pace time.Duration
+		trim float64
+		clock string
+		untilStable bool
+		stableWindow int
+		stableStddev float64
+		decay time.Duration
+		binaryFile string
+		ext string
+		withLoad bool
+		splitByPath bool
+		timestampsDetail bool
+		maxJSONSamples int
+		rcvbuf int
+		onChange float64
+		triesPerSample int
+		maxTries int
+		jsonOutput bool
+		openMetrics bool
+		histBins int
+		maxSamplesMemory int
+		preferISD uint
+		avoidISD uint
+
+		err    error
+		local  *snet.Addr
+		remote *snet.Addr
+	)
+
+	// Fetch arguments from command line
+	flag.StringVar(&sourceAddress, "s", "", "Source SCION Address")
+	flag.StringVar(&destinationAddress, "d", "", "Destination SCION Address")
+	flag.IntVar(&sourcePort, "source-port", 0, "Bind this local port and fail if it is taken, for pinned firewall rules")
+	flag.StringVar(&svcName, "svc", "", "Probe this service (BS, PS, CS or SB) of the -d ISD-AS instead of a host")
+	flag.StringVar(&weightsFile, "weights", "",
+		"With -f, weigh the destinations as listed in this file (DESTINATION WEIGHT lines) into one score")
+	flag.StringVar(&chainList, "chain", "",
+		"Comma separated SCION addresses of a service chain, measured in order with a running total")
+	flag.StringVar(&destinationsFile, "f", "", "Measure each destination listed in this file, one per line")
+	flag.IntVar(&runs, "runs", 1, "With -f, measure the list of destinations this many times")
+	flag.BoolVar(&shuffle, "shuffle", false, "With -f, measure the destinations in a random order in every run")
+	flag.StringVar(&configFile, "config", "", "Config file with default settings")
+	flag.IntVar(&count, "count", NUM_ITERS, "Number of RTT samples to collect, 0 to probe until interrupted")
+	flag.DurationVar(&decay, "decay", 0,
+		"Report the RTT average weighted by recency, samples this much older count half as much")
+	flag.BoolVar(&untilStable, "until-stable", false, fmt.Sprintf(
+		"Stop once the RTT is stable, or after -count samples (default %d)", DEFAULT_STABLE_MAX))
+	flag.IntVar(&stableWindow, "stable-window", DEFAULT_STABLE_WINDOW,
+		"With -until-stable, number of recent samples whose spread decides stability")
+	flag.Float64Var(&stableStddev, "stable-stddev", DEFAULT_STABLE_STDDEV,
+		"With -until-stable, standard deviation in ms below which the RTT is stable")
+	flag.DurationVar(&interval, "interval", 0, "Delay between consecutive probes")
+	flag.DurationVar(&pace, "pace", 0,
+		"Send probes on the ticks of a clock with this period, skipping a tick while a probe is outstanding")
+	flag.Float64Var(&poissonRate, "poisson", 0,
+		"Send probes at this mean rate per second with exponentially distributed gaps instead of -interval")
+	flag.Int64Var(&seed, "seed", 0,
+		"Seed of the random -poisson gaps, -shuffle order, -id-range Ids and sample reservoir, "+
+			"the current time if not set")
+	flag.StringVar(&measureAt, "measure-at", "", "Start measuring at this UTC time of day, HH:MM, for -measure-for")
+	flag.DurationVar(&measureFor, "measure-for", 0, "Length of the -measure-at window")
+	flag.IntVar(&warmup, "warmup", DEFAULT_WARMUP, "Throwaway probes sent just before the -measure-at window")
+	flag.DurationVar(&duration, "duration", 0, "Probe for this long instead of -count and report per-second statistics")
+	flag.DurationVar(&timeout, "timeout", probe.DEFAULT_TIMEOUT, "Time to wait for each reply")
+	flag.IntVar(&triesPerSample, "tries-per-sample", DEFAULT_TRIES_PER_SAMPLE,
+		"Give up after count times this many probes")
+	flag.IntVar(&maxTries, "max-tries", 0, "Give up after this many probes, overrides -tries-per-sample")
+	flag.DurationVar(&retryDelay, "retry-delay", 0, "Jittered back-off after a failed probe (default timeout/4)")
+	flag.BoolVar(&verbose, "v", false, "Verbose output")
+	flag.BoolVar(&showVersion, "version", false, "Print version information and exit")
+	flag.BoolVar(&showConfig, "dump-config", false, "Print the effective settings as JSON and exit")
+	flag.StringVar(&aggregateDir, "aggregate", "", "Summarize the JSON results of earlier runs in this directory and exit")
+	flag.BoolVar(&jsonOutput, "json", false, "Print the summary as JSON")
+	flag.StringVar(&binaryFile, "binary", "",
+		"Write the summary in a compact binary layout to this file, or to stdout instead of the text with \"-\"")
+	flag.BoolVar(&openMetrics, "openmetrics", false, "Print the summary in the OpenMetrics text format")
+	flag.BoolVar(&exemplars, "exemplars", false,
+		"With -openmetrics, add an RTT histogram with the slowest probe of each bucket as exemplar, printed on stdout")
+	flag.BoolVar(&oneline, "oneline", false, "Print the summary as one status line, updated in place on a terminal")
+	flag.IntVar(&sparkWidth, "spark-width", DEFAULT_SPARK_WIDTH,
+		"Number of recent RTTs in the sparkline of the live -oneline status, 0 to disable it")
+	flag.UintVar(&preferISD, "prefer-isd", 0, "Prefer a path transiting this ISD")
+	flag.UintVar(&avoidISD, "avoid-isd", 0, "Prefer a path avoiding this ISD")
+	flag.StringVar(&savePathFile, "save-path", "", "Save the path that is measured over to this file")
+	flag.StringVar(&loadPathFile, "load-path", "", "Measure over the path saved in this file instead of resolving one")
+	flag.StringVar(&pathCacheFile, "path-cache", "",
+		"Reuse the unexpired path of an earlier run cached in this file instead of resolving one")
+	flag.IntVar(&maxHops, "max-hops", 0, "Only measure over paths with at most this many interface hops, 0 for any path")
+	flag.StringVar(&pathPolicyExpr, "path-policy", "",
+		"Only measure over paths matching this policy, e.g. \"+1,-1-ff00:0:110,hops<=8\", see the usage notes")
+	flag.StringVar(&clock, "clock", probe.CLOCK_MONOTONIC, fmt.Sprintf("Clock to time probes with, %s or %s",
+		probe.CLOCK_MONOTONIC, probe.CLOCK_WALL))
+	flag.BoolVar(&timestampsDetail, "timestamps-detail", false,
+		"With -json, add the RTT of every sample and the time its probe was sent")
+	flag.IntVar(&maxJSONSamples, "max-json-samples", 0,
+		"With -timestamps-detail, only include the first and last this many samples in total, 0 for all")
+	flag.Float64Var(&trim, "trim", 0,
+		"Also report the mean RTT without the lowest and highest this many percent of the samples")
+	flag.IntVar(&histBins, "hist-bins", DEFAULT_HIST_BINS, "Number of bins of the RTT histogram printed with -v")
+	flag.IntVar(&maxSamplesMemory, "max-samples-memory", DEFAULT_MAX_SAMPLES_MEMORY,
+		"With -count 0, samples kept in memory before statistics become approximate")
+	flag.StringVar(&mode, "mode", probe.MODE_SCMP,
+		"Probe with SCMP echo (scmp) or with UDP to an echo responder (udp)")
+	flag.BoolVar(&showBind, "show-bind", false, "Print the local SCION address the socket is bound to")
+	flag.Int64Var(&maxBytes, "max-bytes", 0, "Stop once this many bytes have been sent, 0 for no limit")
+	flag.IntVar(&size, "size", 0, "Pad each probe's payload to this many bytes")
+	flag.BoolVar(&clampSize, "clamp-size", false, "Reduce a -size that does not fit the path MTU to the largest that does")
+	flag.BoolVar(&ttfb, "ttfb", false, "Follow each -size probe with a minimal one to separate propagation from transmission delay")
+	flag.StringVar(&execCommand, "exec", "", "Shell command to run on completion with the JSON result on its stdin")
+	flag.BoolVar(&noOneway, "no-oneway", false, "Do not report the one-way latency estimate of RTT/2")
+	flag.BoolVar(&useSyslog, "syslog", false, "Also send the summary to syslog")
+	flag.StringVar(&statsdAddr, "statsd", "", "Also send the RTT metrics to this StatsD host:port (UDP)")
+	flag.StringVar(&sloSpec, "slo", "",
+		"Report against this RTT/loss objective, e.g. 50ms/1%, and exit with a failure code if it is missed")
+	flag.Float64Var(&maxRTT, "max-rtt", 0, "Report a breach if the average RTT exceeds this many ms, 0 for no limit")
+	flag.Float64Var(&maxLoss, "max-loss", 0, "Report a breach if the loss exceeds this percentage, 0 for no limit")
+	flag.StringVar(&grafanaURL, "grafana-url", "", "Post an annotation to this Grafana on a -max-rtt or -max-loss breach")
+	flag.StringVar(&grafanaToken, "grafana-token", "",
+		"API token for -grafana-url, read from $GRAFANA_TOKEN if not given")
+	flag.BoolVar(&statsdTags, "statsd-tags", false, "Tag the -statsd metrics with src, dst and mode (DogStatsD)")
+	flag.StringVar(&syslogAddr, "syslog-addr", "", "Remote syslog host:port (UDP) for -syslog, local syslog if empty")
+	flag.IntVar(&dscp, "dscp", 0, "DSCP value to mark probes with (not supported by this SCION version)")
+	flag.BoolVar(&strictSrc, "strict-src", false, "Reject replies that do not come from the destination IA and host")
+	flag.BoolVar(&checksum, "checksum", false,
+		"Pad probes with random bytes and count replies whose payload checksum does not match as corrupted")
+	flag.BoolVar(&looseId, "loose-id", false,
+		"Accept echo replies whose Id was rewritten on the way if their sequence number matches")
+	flag.StringVar(&interleaveAddress, "interleave", "", "Second destination SCION Address to alternate probes with")
+	flag.BoolVar(&both, "both", false,
+		"Alternate SCMP echo and UDP probes to the responder to tell network RTT from application RTT")
+	flag.StringVar(&outputPath, "output", "", "Write a record of every probe to this file, - for stdout")
+	flag.StringVar(&parquetPath, "parquet", "",
+		"Write every reply and the summary to this Parquet file for analysis in pandas or DuckDB")
+	flag.DurationVar(&rotate, "rotate", 0, "Start a new timestamped -output file every this long, e.g. 1h")
+	flag.StringVar(&outputFormat, "format", FORMAT_NDJSON, "Format of the -output records (ndjson or csv)")
+	flag.BoolVar(&absTimes, "abs-times", false, "Include absolute send and receive times of each probe in -output and -v")
+	flag.BoolVar(&compress, "gzip", false, "Gzip compress the -output file, implied by a .gz suffix")
+	flag.StringVar(&pipePath, "pipe", "", "Stream seq,rtt_ms lines of every reply to this named pipe")
+	flag.BoolVar(&allPaths, "probe-all-paths", false, "Measure every path with count probes and rank them by RTT")
+	flag.BoolVar(&pinBest, "pin-best", false, "With -probe-all-paths, go on to measure over the best path")
+	flag.StringVar(&tieBreak, "tie-break", TIE_FIRST,
+		"How -probe-all-paths ranks paths with near-identical RTT: fewest hops, largest mtu or first by description")
+	flag.Float64Var(&tieTolerance, "tie-tolerance", 0, "RTT difference in ms within which paths are considered tied")
+	flag.Float64Var(&onChange, "on-change", 0,
+		"With -count 0, print window summaries only when the RTT changes by this many percent or loss shifts")
+	flag.Uint64Var(&echoId, "id", 0, "Send every SCMP echo request with this Id instead of a random one")
+	flag.StringVar(&idRangeSpec, "id-range", "", "Draw the random SCMP echo request Ids from this range, LO-HI")
+	flag.BoolVar(&skipSocketCheck, "skip-socket-check", false,
+		"Do not check for the dispatcher and sciond sockets, for setups where they appear lazily")
+	flag.BoolVar(&perInterface, "per-interface", false,
+		"Measure the RTT to the router of every hop along the path, count probes each")
+	flag.StringVar(&sizeSweepSpec, "size-sweep", "",
+		"Measure the RTT for every payload size LO:HI:STEP, count probes each, to find the path MTU")
+	flag.BoolVar(&pingCompat, "ping-compat", false, "Print a line per reply and the summary in the format of ping")
+	flag.BoolVar(&bidir, "bidir", false,
+		"Have dataplane_server -bidir probe back while probing it and compare both RTT series")
+	flag.BoolVar(&voip, "voip", false, "Probe like a voice stream for -duration and size a jitter buffer")
+	flag.Float64Var(&voipLoss, "voip-loss", DEFAULT_VOIP_LOSS, "Loss in percent the -voip jitter buffer may cause")
+	flag.BoolVar(&emitCmdline, "emit-cmdline", false, "Include the command line that reproduces the run in the output")
+	flag.StringVar(&redactFlags, "redact", "exec,syslog-addr,statsd,grafana-token",
+		"Comma separated flags whose values -emit-cmdline and -dump-config hide")
+	flag.BoolVar(&calibrateOverhead, "calibrate", false, "Estimate the local overhead in each RTT by echoing to the local host")
+	flag.BoolVar(&subtractOverhead, "subtract-overhead", false, "With -calibrate, also report RTTs minus the overhead")
+	flag.StringVar(&baselineFile, "baseline", "", "Compare the result to this earlier -json result")
+	flag.Float64Var(&regressThreshold, "regress-threshold", DEFAULT_REGRESS_THRESHOLD,
+		"Percent increase of the RTT, or percentage points of loss, over -baseline that is a regression")
+	flag.UintVar(&seqStart, "seq-start", 0, "Sequence number of the first SCMP echo request, 0 to 65535")
+	flag.BoolVar(&anonymize, "anonymize", false, "Replace the host addresses in the output by pseudonyms")
+	flag.BoolVar(&anonymizeAS, "anonymize-as", false, "With -anonymize, replace the AS numbers by pseudonyms too")
+	flag.BoolVar(&trackResponders, "responders", false,
+		"Accept replies from any host, as from an anycast destination, and report which one answered")
+	flag.StringVar(&captureFile, "capture", "", "Write every probe packet sent and received to this pcapng file")
+	flag.IntVar(&sndbuf, "sndbuf", 0, "Send buffer size in bytes of the socket, 0 for the system default")
+	flag.IntVar(&rcvbuf, "rcvbuf", 0, "Receive buffer size in bytes of the socket, 0 for the system default")
+	flag.BoolVar(&splitByPath, "split-by-path", false,
+		"Also report statistics for each path the replies took, if load balancing spreads them")
+	flag.BoolVar(&withLoad, "with-load", false,
+		"Sample the local load average with every probe and report its correlation with the RTT")
+	flag.StringVar(&ext, "ext", "", "Attach this hop-by-hop extension to the echo requests, one of: scmp")
+	flag.BoolVar(&toBorder, "to-border", false, "Measure to the border router of the destination AS instead of the host")
+	flag.Parse()
+
+	if showVersion {
+		fmt.Printf("controlplane_client %s (commit %s, built %s)\n", Version, Commit, BuildDate)
+		os.Exit(0)
+	}
+
+	// Aggregation is offline, no addresses or SCION connectivity are needed
+	if len(aggregateDir) > 0 {
+		check(aggregate(aggregateDir))
+		return
+	}
+
+	// Listed once -anonymize is known, the config file may set it
+	var applied []string
+	if len(configFile) > 0 {
+		settings, err := readConfigFile(configFile)
+		check(err)
+		applied, err = applyConfig(settings)
+		check(err)
+	}
+
+	if showConfig {
+		check(dumpConfig(strings.Split(redactFlags, ",")))
+		return
+	}
+
+	if count < 0 {
+		check(fmt.Errorf("Error, count cannot be negative"))
+	}
+	if duration < 0 {
+		check(fmt.Errorf("Error, duration cannot be negative"))
+	}
+	var measureClock time.Time
+	if len(measureAt) > 0 {
+		measureClock, err = time.Parse("15:04", measureAt)
+		if err != nil {
+			check(fmt.Errorf("Error, invalid -measure-at %q, expected HH:MM", measureAt))
+		}
+		if measureFor <= 0 {
+			check(fmt.Errorf("Error, -measure-at requires a positive -measure-for"))
+		}
+		if isFlagSet("duration") || isFlagSet("count") {
+			check(fmt.Errorf("Error, -measure-at cannot be combined with -duration or -count"))
+		}
+		if len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || len(chainList) > 0 || voip ||
+			perInterface || len(sizeSweepSpec) > 0 || untilStable {
+			check(fmt.Errorf("Error, -measure-at cannot be combined with -interleave, -both, -f, -chain, -voip, " +
+				"-per-interface, -size-sweep or -until-stable"))
+		}
+		if warmup < 0 {
+			check(fmt.Errorf("Error, -warmup cannot be negative"))
+		}
+		duration = measureFor
+	} else if isFlagSet("measure-for") || isFlagSet("warmup") {
+		check(fmt.Errorf("Error, -measure-for and -warmup require -measure-at"))
+	}
+	// A timed run is a continuous one that stops itself
+	if duration > 0 {
+		if isFlagSet("count") {
+			check(fmt.Errorf("Error, -duration cannot be combined with -count"))
+		}
+		count = 0
+	}
+	if decay < 0 {
+		check(fmt.Errorf("Error, -decay half-life cannot be negative"))
+	}
+	if untilStable {
+		if duration > 0 || (isFlagSet("count") && count == 0) {
+			check(fmt.Errorf("Error, -until-stable cannot be combined with -duration or -count 0"))
+		}
+		if len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || voip || perInterface {
+			check(fmt.Errorf("Error, -until-stable cannot be combined with -interleave, -both, -f, -voip " +
+				"or -per-interface"))
+		}
+		if stableWindow < 2 || stableStddev <= 0 {
+			check(fmt.Errorf("Error, -stable-window must be at least 2 and -stable-stddev positive"))
+		}
+		if !isFlagSet("count") {
+			count = DEFAULT_STABLE_MAX
+		}
+		if count < stableWindow {
+			check(fmt.Errorf("Error, -count must be at least -stable-window with -until-stable"))
+		}
+	}
+	if maxSamplesMemory < 1 {
+		check(fmt.Errorf("Error, max samples in memory must be at least 1"))
+	}
+	if interval < 0 {
+		check(fmt.Errorf("Error, interval cannot be negative"))
+	}
+	if timeout <= 0 {
+		check(fmt.Errorf("Error, timeout must be positive"))
+	}
+	if triesPerSample < 1 {
+		check(fmt.Errorf("Error, tries per sample must be at least 1"))
+	}
+	if maxTries < 0 {
+		check(fmt.Errorf("Error, maximum number of tries cannot be negative"))
+	}
+	if maxTries > 0 && maxTries < count {
+		check(fmt.Errorf("Error, maximum number of tries cannot be less than count"))
+	}
+	if !isFlagSet("retry-delay") {
+		retryDelay = timeout / 4
+	}
+	if retryDelay < 0 {
+		check(fmt.Errorf("Error, retry delay cannot be negative"))
+	}
+	if mode != probe.MODE_SCMP && mode != probe.MODE_UDP {
+		check(fmt.Errorf("Error, unknown mode %q, expected %s or %s", mode, probe.MODE_SCMP, probe.MODE_UDP))
+	}
+	if toBorder && mode != probe.MODE_SCMP {
+		check(fmt.Errorf("Error, -to-border requires -mode %s", probe.MODE_SCMP))
+	}
+	if maxBytes < 0 {
+		check(fmt.Errorf("Error, byte budget cannot be negative"))
+	}
+	if size < 0 {
+		check(fmt.Errorf("Error, size cannot be negative"))
+	}
+	if mode == probe.MODE_SCMP && size > probe.MAX_SCMP_SIZE {
+		check(fmt.Errorf("Error, SCMP probes are limited to %d bytes", probe.MAX_SCMP_SIZE))
+	}
+	if toBorder && size > 0 {
+		check(fmt.Errorf("Error, -size cannot be used with -to-border"))
+	}
+	if checksum && (toBorder || len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 ||
+		len(chainList) > 0) {
+		check(fmt.Errorf("Error, -checksum cannot be combined with -to-border, -interleave, -both, -f or -chain"))
+	}
+	if anonymizeAS && !anonymize {
+		check(fmt.Errorf("Error, -anonymize-as requires -anonymize"))
+	}
+	if anonymize {
+		Anon = newAnonymizer(anonymizeAS)
+		log.SetOutput(anonymizedLog{os.Stderr})
+	}
+	if verbose {
+		for _, name := range applied {
+			progressf("Config: %s = %s (from %s)\n", name, flag.Lookup(name).Value, configFile)
+		}
+	}
+	var sweep *sizeSweep
+	if len(sizeSweepSpec) > 0 {
+		sweep, err = parseSizeSweep(sizeSweepSpec)
+		check(err)
+		if mode == probe.MODE_SCMP && sweep.hi > probe.MAX_SCMP_SIZE {
+			check(fmt.Errorf("Error, SCMP probes are limited to %d bytes", probe.MAX_SCMP_SIZE))
+		}
+		if isFlagSet("size") || toBorder || ttfb || count == 0 || oneline {
+			check(fmt.Errorf("Error, -size-sweep cannot be combined with -size, -to-border, -ttfb, -count 0 " +
+				"or -oneline"))
+		}
+		if len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || len(chainList) > 0 || voip ||
+			perInterface || allPaths {
+			check(fmt.Errorf("Error, -size-sweep cannot be combined with -interleave, -both, -f, -chain, " +
+				"-voip, -per-interface or -probe-all-paths"))
+		}
+		// The buffers are sized for the largest probe when the path is set up
+		size = sweep.hi
+	}
+	if isFlagSet("id") && (mode != probe.MODE_SCMP || toBorder) {
+		check(fmt.Errorf("Error, -id only applies to SCMP echo requests, not -mode %s or -to-border",
+			probe.MODE_UDP))
+	}
+	var ids *probe.IdRange
+	if len(idRangeSpec) > 0 {
+		if isFlagSet("id") || mode != probe.MODE_SCMP || toBorder {
+			check(fmt.Errorf("Error, -id-range cannot be combined with -id, -mode %s or -to-border", probe.MODE_UDP))
+		}
+		var err error
+		ids, err = probe.ParseIdRange(idRangeSpec)
+		check(err)
+	}
+	if seqStart > 0xffff {
+		check(fmt.Errorf("Error, -seq-start must fit in 16 bits"))
+	}
+	if isFlagSet("seq-start") && (mode != probe.MODE_SCMP || toBorder) {
+		check(fmt.Errorf("Error, -seq-start only applies to SCMP echo requests, not -mode %s or -to-border",
+			probe.MODE_UDP))
+	}
+	if ttfb && size == 0 {
+		check(fmt.Errorf("Error, -ttfb requires a -size for the large probe"))
+	}
+	if dscp < 0 || dscp > 63 {
+		check(fmt.Errorf("Error, DSCP must be between 0 and 63"))
+	}
+	// Fail rather than silently sending unmarked probes
+	if isFlagSet("dscp") {
+		check(fmt.Errorf("Error, cannot mark probes with DSCP %d: the SCION common header has no "+
+			"traffic class field and packets leave through the dispatcher's socket", dscp))
+	}
+	if len(interleaveAddress) > 0 && (count == 0 || ttfb || maxBytes > 0) {
+		check(fmt.Errorf("Error, -interleave cannot be combined with -count 0, -ttfb or -max-bytes"))
+	}
+	if both && (isFlagSet("mode") || toBorder || len(interleaveAddress) > 0 || count == 0 || ttfb || maxBytes > 0) {
+		check(fmt.Errorf("Error, -both cannot be combined with -mode, -to-border, -interleave, -count 0, -ttfb " +
+			"or -max-bytes"))
+	}
+	if both && (openMetrics || oneline || len(outputPath) > 0 || len(destinationsFile) > 0 || allPaths ||
+		perInterface || voip || len(baselineFile) > 0 || poissonRate > 0 || pace > 0 || len(captureFile) > 0 ||
+		len(savePathFile) > 0) {
+		check(fmt.Errorf("Error, -both cannot be combined with -openmetrics, -oneline, -output, -f, " +
+			"-probe-all-paths, -per-interface, -voip, -baseline, -poisson, -pace, -capture or -save-path"))
+	}
+	if binaryFile == "-" && (jsonOutput || openMetrics || oneline || outputPath == "-") {
+		check(fmt.Errorf("Error, -binary - cannot be combined with -json, -openmetrics, -oneline or -output -"))
+	}
+	if len(binaryFile) > 0 && (len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || voip ||
+		perInterface) {
+		check(fmt.Errorf("Error, -binary cannot be combined with -interleave, -both, -f, -voip or -per-interface"))
+	}
+	if maxRTT < 0 || maxLoss < 0 {
+		check(fmt.Errorf("Error, -max-rtt and -max-loss cannot be negative"))
+	}
+	// An SLO sets the same limits, so a breach of it is also annotated in Grafana
+	if len(sloSpec) > 0 {
+		if isFlagSet("max-rtt") || isFlagSet("max-loss") {
+			check(fmt.Errorf("Error, -slo cannot be combined with -max-rtt or -max-loss"))
+		}
+		var err error
+		maxRTT, maxLoss, err = parseSLO(sloSpec)
+		check(err)
+	}
+	if len(grafanaURL) > 0 && maxRTT == 0 && maxLoss == 0 {
+		check(fmt.Errorf("Error, -grafana-url requires -max-rtt or -max-loss"))
+	}
+	if (maxRTT > 0 || maxLoss > 0) && (len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || voip ||
+		perInterface) {
+		check(fmt.Errorf("Error, -max-rtt and -max-loss cannot be combined with -interleave, -both, -f, -voip " +
+			"or -per-interface"))
+	}
+	if len(grafanaToken) == 0 {
+		grafanaToken = os.Getenv("GRAFANA_TOKEN")
+	}
+	if splitByPath && (len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || voip || perInterface ||
+		toBorder) {
+		check(fmt.Errorf("Error, -split-by-path cannot be combined with -interleave, -both, -f, -voip, " +
+			"-per-interface or -to-border"))
+	}
+	if withLoad {
+		if _, err := readLoadAvg(); err != nil {
+			check(fmt.Errorf("Error, -with-load needs /proc/loadavg: %v", err))
+		}
+		if len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || voip || perInterface {
+			check(fmt.Errorf("Error, -with-load cannot be combined with -interleave, -both, -f, -voip or -per-interface"))
+		}
+	}
+	if len(ext) > 0 {
+		if _, ok := probe.EXTENSIONS[ext]; !ok {
+			check(fmt.Errorf("Error, unknown extension %q", ext))
+		}
+		if mode != probe.MODE_SCMP || toBorder || len(interleaveAddress) > 0 || len(destinationsFile) > 0 || both {
+			check(fmt.Errorf("Error, -ext requires -mode %s and cannot be combined with -to-border, "+
+				"-interleave, -f or -both", probe.MODE_SCMP))
+		}
+	}
+	if jsonOutput && openMetrics {
+		check(fmt.Errorf("Error, -json and -openmetrics cannot be combined"))
+	}
+	if openMetrics && len(interleaveAddress) > 0 {
+		check(fmt.Errorf("Error, -openmetrics cannot be combined with -interleave"))
+	}
+	if outputFormat != FORMAT_NDJSON && outputFormat != FORMAT_CSV {
+		check(fmt.Errorf("Error, unknown output format %q, expected %s or %s", outputFormat, FORMAT_NDJSON, FORMAT_CSV))
+	}
+	if len(outputPath) > 0 && len(interleaveAddress) > 0 {
+		check(fmt.Errorf("Error, -output cannot be combined with -interleave"))
+	}
+	if len(parquetPath) > 0 && (len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 ||
+		len(chainList) > 0 || voip || perInterface || len(sizeSweepSpec) > 0) {
+		check(fmt.Errorf("Error, -parquet cannot be combined with -interleave, -both, -f, -chain, -voip, " +
+			"-per-interface or -size-sweep"))
+	}
+	if strings.HasSuffix(outputPath, ".gz") {
+		compress = true
+	}
+	if rotate < 0 {
+		check(fmt.Errorf("Error, -rotate cannot be negative"))
+	}
+	if rotate > 0 && (len(outputPath) == 0 || outputPath == "-") {
+		check(fmt.Errorf("Error, -rotate requires an -output file"))
+	}
+	// Compressed data is of no use on a terminal
+	if compress && outputPath == "-" {
+		log.Printf("Warning, not compressing output written to stdout")
+		compress = false
+	}
+	if absTimes && len(outputPath) == 0 && !verbose {
+		check(fmt.Errorf("Error, -abs-times requires -output or -v"))
+	}
+	if runs < 1 {
+		check(fmt.Errorf("Error, number of runs must be at least 1"))
+	}
+	if (isFlagSet("runs") || shuffle) && len(destinationsFile) == 0 {
+		check(fmt.Errorf("Error, -runs and -shuffle require -f"))
+	}
+	if len(destinationsFile) > 0 && len(destinationAddress) > 0 {
+		check(fmt.Errorf("Error, -f cannot be combined with -d"))
+	}
+	if len(destinationsFile) > 0 && (count == 0 || len(interleaveAddress) > 0 || allPaths || ttfb ||
+		len(outputPath) > 0 || len(pipePath) > 0 || openMetrics || len(execCommand) > 0 || useSyslog ||
+		len(statsdAddr) > 0) {
+		check(fmt.Errorf("Error, -f cannot be combined with -count 0, -duration, -interleave, -probe-all-paths, " +
+			"-ttfb, -output, -pipe, -openmetrics, -exec, -syslog or -statsd"))
+	}
+	if len(weightsFile) > 0 && len(destinationsFile) == 0 {
+		check(fmt.Errorf("Error, -weights requires -f"))
+	}
+	if len(chainList) > 0 && (len(destinationAddress) > 0 || len(destinationsFile) > 0) {
+		check(fmt.Errorf("Error, -chain cannot be combined with -d or -f"))
+	}
+	if len(chainList) > 0 && (count == 0 || len(interleaveAddress) > 0 || allPaths || ttfb ||
+		len(outputPath) > 0 || len(pipePath) > 0 || openMetrics || len(execCommand) > 0 || useSyslog ||
+		len(statsdAddr) > 0 || both || voip || perInterface || oneline) {
+		check(fmt.Errorf("Error, -chain cannot be combined with -count 0, -duration, -interleave, " +
+			"-probe-all-paths, -ttfb, -output, -pipe, -openmetrics, -exec, -syslog, -statsd, -both, -voip, " +
+			"-per-interface or -oneline"))
+	}
+	if perInterface && (mode != probe.MODE_SCMP || toBorder || size > 0 || count == 0) {
+		check(fmt.Errorf("Error, -per-interface requires -mode %s and cannot be combined with -to-border, "+
+			"-size or -count 0", probe.MODE_SCMP))
+	}
+	if perInterface && (len(interleaveAddress) > 0 || allPaths || len(destinationsFile) > 0) {
+		check(fmt.Errorf("Error, -per-interface cannot be combined with -interleave, -probe-all-paths or -f"))
+	}
+	if pingCompat && (jsonOutput || openMetrics || oneline || len(binaryFile) > 0 || isFlagSet("on-change")) {
+		check(fmt.Errorf("Error, -ping-compat cannot be combined with -json, -openmetrics, -oneline, -binary " +
+			"or -on-change"))
+	}
+	if pingCompat && (len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || len(chainList) > 0 ||
+		voip || perInterface || len(sizeSweepSpec) > 0 || bidir || (allPaths && !pinBest)) {
+		check(fmt.Errorf("Error, -ping-compat cannot be combined with -interleave, -both, -f, -chain, -voip, " +
+			"-per-interface, -size-sweep, -bidir or -probe-all-paths without -pin-best"))
+	}
+	if oneline && (jsonOutput || openMetrics || isFlagSet("on-change")) {
+		check(fmt.Errorf("Error, -oneline cannot be combined with -json, -openmetrics or -on-change"))
+	}
+	if oneline && (len(interleaveAddress) > 0 || len(destinationsFile) > 0 || perInterface || (allPaths && !pinBest)) {
+		check(fmt.Errorf("Error, -oneline cannot be combined with -interleave, -f, -per-interface " +
+			"or -probe-all-paths without -pin-best"))
+	}
+	if bidir && (mode != probe.MODE_UDP || count == 0) {
+		check(fmt.Errorf("Error, -bidir requires -mode %s and a -count", probe.MODE_UDP))
+	}
+	if bidir && count > probe.BIDIR_MAX_COUNT {
+		check(fmt.Errorf("Error, -bidir supports a -count of at most %d, the server sends no more probes",
+			probe.BIDIR_MAX_COUNT))
+	}
+	if bidir && (len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || len(chainList) > 0 || voip ||
+		perInterface || len(sizeSweepSpec) > 0 || allPaths || untilStable || len(measureAt) > 0 ||
+		len(outputPath) > 0 || len(parquetPath) > 0 || len(binaryFile) > 0 || openMetrics || oneline) {
+		check(fmt.Errorf("Error, -bidir cannot be combined with -interleave, -both, -f, -chain, -voip, " +
+			"-per-interface, -size-sweep, -probe-all-paths, -until-stable, -measure-at, -output, -parquet, " +
+			"-binary, -openmetrics or -oneline"))
+	}
+	if voip && (duration == 0 || isFlagSet("interval")) {
+		check(fmt.Errorf("Error, -voip requires -duration and sets its own interval"))
+	}
+	if voip && (len(interleaveAddress) > 0 || len(destinationsFile) > 0 || perInterface || allPaths || oneline ||
+		openMetrics) {
+		check(fmt.Errorf("Error, -voip cannot be combined with -interleave, -f, -per-interface, -probe-all-paths, " +
+			"-oneline or -openmetrics"))
+	}
+	if voipLoss < 0 || voipLoss > 100 {
+		check(fmt.Errorf("Error, -voip-loss must be a percentage"))
+	}
+	if calibrateOverhead && mode != probe.MODE_SCMP {
+		check(fmt.Errorf("Error, -calibrate requires -mode %s", probe.MODE_SCMP))
+	}
+	if subtractOverhead && !calibrateOverhead {
+		check(fmt.Errorf("Error, -subtract-overhead requires -calibrate"))
+	}
+	if regressThreshold < 0 {
+		check(fmt.Errorf("Error, regression threshold cannot be negative"))
+	}
+	if len(baselineFile) > 0 && (len(interleaveAddress) > 0 || len(destinationsFile) > 0 || perInterface || voip ||
+		(allPaths && !pinBest)) {
+		check(fmt.Errorf("Error, -baseline cannot be combined with -interleave, -f, -per-interface, -voip " +
+			"or -probe-all-paths without -pin-best"))
+	}
+	if looseId && (mode != probe.MODE_SCMP || toBorder) {
+		check(fmt.Errorf("Error, -loose-id requires -mode %s and cannot be combined with -to-border",
+			probe.MODE_SCMP))
+	}
+	// Replies from any instance of an anycast destination are expected
+	if trackResponders && (toBorder || strictSrc) {
+		check(fmt.Errorf("Error, -responders cannot be combined with -to-border or -strict-src"))
+	}
+	if sparkWidth < 0 {
+		check(fmt.Errorf("Error, -spark-width cannot be negative"))
+	}
+	if exemplars && !openMetrics {
+		check(fmt.Errorf("Error, -exemplars requires -openmetrics"))
+	}
+	if poissonRate < 0 {
+		check(fmt.Errorf("Error, -poisson rate cannot be negative"))
+	}
+	if poissonRate > 0 && (isFlagSet("interval") || voip || len(interleaveAddress) > 0 || len(destinationsFile) > 0 ||
+		perInterface) {
+		check(fmt.Errorf("Error, -poisson cannot be combined with -interval, -voip, -interleave, -f or -per-interface"))
+	}
+	if !isFlagSet("seed") {
+		seed = time.Now().UnixNano()
+	}
+	if pace < 0 {
+		check(fmt.Errorf("Error, -pace cannot be negative"))
+	}
+	if pace > 0 && (isFlagSet("interval") || poissonRate > 0 || voip || len(interleaveAddress) > 0 ||
+		len(destinationsFile) > 0 || perInterface) {
+		check(fmt.Errorf("Error, -pace cannot be combined with -interval, -poisson, -voip, -interleave, -f " +
+			"or -per-interface"))
+	}
+	if len(captureFile) > 0 && (len(interleaveAddress) > 0 || len(destinationsFile) > 0) {
+		check(fmt.Errorf("Error, -capture cannot be combined with -interleave or -f"))
+	}
+	if sndbuf < 0 || rcvbuf < 0 {
+		check(fmt.Errorf("Error, socket buffer sizes cannot be negative"))
+	}
+	if statsdTags && len(statsdAddr) == 0 {
+		check(fmt.Errorf("Error, -statsd-tags requires -statsd"))
+	}
+	if allPaths && len(interleaveAddress) > 0 {
+		check(fmt.Errorf("Error, -probe-all-paths cannot be combined with -interleave"))
+	}
+	if pinBest && !allPaths {
+		check(fmt.Errorf("Error, -pin-best requires -probe-all-paths"))
+	}
+	if tieBreak != TIE_HOPS && tieBreak != TIE_MTU && tieBreak != TIE_FIRST {
+		check(fmt.Errorf("Error, unknown tie-break policy %q, expected %s, %s or %s", tieBreak, TIE_HOPS, TIE_MTU,
+			TIE_FIRST))
+	}
+	if tieTolerance < 0 {
+		check(fmt.Errorf("Error, tie tolerance cannot be negative"))
+	}
+	if onChange < 0 {
+		check(fmt.Errorf("Error, -on-change threshold cannot be negative"))
+	}
+	if isFlagSet("on-change") && count != 0 {
+		check(fmt.Errorf("Error, -on-change requires -count 0"))
+	}
+	if isFlagSet("on-change") && openMetrics {
+		check(fmt.Errorf("Error, -on-change cannot be combined with -openmetrics"))
+	}
+	if clock != probe.CLOCK_MONOTONIC && clock != probe.CLOCK_WALL {
+		check(fmt.Errorf("Error, unknown clock %q, expected %s or %s", clock, probe.CLOCK_MONOTONIC,
+			probe.CLOCK_WALL))
+	}
+	if timestampsDetail && !jsonOutput {
+		check(fmt.Errorf("Error, -timestamps-detail requires -json"))
+	}
+	if maxJSONSamples < 0 {
+		check(fmt.Errorf("Error, -max-json-samples cannot be negative"))
+	}
+	if maxJSONSamples > 0 && !timestampsDetail {
+		check(fmt.Errorf("Error, -max-json-samples requires -timestamps-detail"))
+	}
+	if trim < 0 || trim >= 50 {
+		check(fmt.Errorf("Error, -trim must be at least 0 and below 50 percent"))
+	}
+	if histBins < 1 {
+		check(fmt.Errorf("Error, number of histogram bins must be at least 1"))
+	}
+	if len(loadPathFile) > 0 && (allPaths || maxHops > 0 || preferISD != 0 || avoidISD != 0 ||
+		len(pathPolicyExpr) > 0) {
+		check(fmt.Errorf("Error, -load-path cannot be combined with -probe-all-paths, -max-hops, -prefer-isd, " +
+			"-avoid-isd or -path-policy"))
+	}
+	var policy *probe.PathPolicy
+	if len(pathPolicyExpr) > 0 {
+		var err error
+		policy, err = probe.ParsePathPolicy(pathPolicyExpr)
+		check(err)
+	}
+	if (len(loadPathFile) > 0 || len(savePathFile) > 0) && (len(interleaveAddress) > 0 || len(destinationsFile) > 0) {
+		check(fmt.Errorf("Error, -load-path and -save-path cannot be combined with -interleave or -f"))
+	}
+	if len(pathCacheFile) > 0 && (len(loadPathFile) > 0 || allPaths || len(interleaveAddress) > 0 ||
+		len(destinationsFile) > 0) {
+		check(fmt.Errorf("Error, -path-cache cannot be combined with -load-path, -probe-all-paths, -interleave or -f"))
+	}
+	if maxHops < 0 {
+		check(fmt.Errorf("Error, maximum number of hops cannot be negative"))
+	}
+	if preferISD > 0xffff || avoidISD > 0xffff {
+		check(fmt.Errorf("Error, ISD numbers must fit in 16 bits"))
+	}
+	if preferISD != 0 && preferISD == avoidISD {
+		check(fmt.Errorf("Error, cannot both prefer and avoid ISD %d", preferISD))
+	}
+
+	// Create the SCION UDP socket
+	if len(sourceAddress) > 0 {
+		local, err = snet.AddrFromString(sourceAddress)
+		check(err)
+		if sourcePort < 0 || sourcePort > 0xffff {
+			check(fmt.Errorf("Error, -source-port must be between 1 and 65535"))
+		}
+		if sourcePort > 0 && local.L4Port != 0 && int(local.L4Port) != sourcePort {
+			check(fmt.Errorf("Error, -source-port %d conflicts with port %d of -s", sourcePort, local.L4Port))
+		}
+		if sourcePort > 0 {
+			local.L4Port = uint16(sourcePort)
+		}
+	} else {
+		printUsage()
+		check(fmt.Errorf("Error, source address needs to be specified with -s"))
+	}
+	// The border router of the destination AS picks the service instance, the instances that
+	// answered are reported like the responders of an anycast address
+	if len(svcName) > 0 {
+		svc := addr.HostSVCFromString(strings.ToUpper(svcName))
+		if svc == addr.SvcNone {
+			check(fmt.Errorf("Error, unknown service %q, expected BS, PS, CS or SB", svcName))
+		}
+		if mode != probe.MODE_SCMP || toBorder {
+			check(fmt.Errorf("Error, -svc requires -mode %s and cannot be combined with -to-border", probe.MODE_SCMP))
+		}
+		if len(interleaveAddress) > 0 || both || len(destinationsFile) > 0 || len(chainList) > 0 || bidir {
+			check(fmt.Errorf("Error, -svc cannot be combined with -interleave, -both, -f, -chain or -bidir"))
+		}
+		ia, err := addr.IAFromString(destinationAddress)
+		if err != nil {
+			check(fmt.Errorf("Error, with -svc the destination is an ISD-AS, not %q", destinationAddress))
+		}
+		if ia.Eq(local.IA) {
+			check(fmt.Errorf("Error, -svc needs a remote AS, services of the local AS are not resolved"))
+		}
+		remote = &snet.Addr{IA: ia, Host: svc}
+		destinationAddress = fmt.Sprintf("%s,[%s]", ia, strings.ToUpper(svcName))
+		trackResponders = true
+	} else if len(destinationAddress) > 0 {
+		remote, err = snet.AddrFromString(destinationAddress)
+		check(err)
+	} else if len(destinationsFile) == 0 && len(chainList) == 0 {
+		printUsage()
+		check(fmt.Errorf("Error, destination address needs to be specified with -d"))
+	}
+
+	dispatcherAddr := probe.DEFAULT_DISPATCHER
+	sciondAddr := sciond.GetDefaultSCIONDPath(nil)
+	if !skipSocketCheck {
+		check(checkSockets(dispatcherAddr, sciondAddr))
+	}
+	snet.Init(local.IA, sciondAddr, dispatcherAddr)
+
+	// An explicit -max-tries wins over -tries-per-sample
+	max_tries := count * triesPerSample
+	if maxTries > 0 {
+		max_tries = maxTries
+	}
+
+	config := probe.Config{
+		Mode:       mode,
+		Timeout:    timeout,
+		Size:       size,
+		StrictSrc:  strictSrc,
+		ToBorder:   toBorder,
+		FixedId:    isFlagSet("id"),
+		Id:         echoId,
+		Ids:        ids,
+		IdSource:   rand.NewSource(seed),
+		LooseId:    looseId,
+		Seq:        uint16(seqStart),
+		FixedPort:  sourcePort > 0,
+		MaxHops:    maxHops,
+		Policy:     policy,
+		PreferISD:  addr.ISD(preferISD),
+		AvoidISD:   addr.ISD(avoidISD),
+		Dispatcher: dispatcherAddr,
+		Clock:      clock,
+	}
+
+	if len(destinationsFile) > 0 || len(chainList) > 0 {
+		Seed = rand.NewSource(seed)
+		if len(chainList) > 0 {
+			runChain(local, config, splitChain(chainList), count, max_tries, interval, verbose, jsonOutput)
+			return
+		}
+		destinations, err := readDestinations(destinationsFile)
+		check(err)
+		var weights map[string]float64
+		if len(weightsFile) > 0 {
+			weights, err = readWeights(weightsFile)
+			check(err)
+		}
+		runBatch(local, config, destinations, runs, shuffle, seed, count, max_tries, interval, verbose,
+			jsonOutput, weights)
+		return
+	}
+
+	config.Ext = ext
+	config.Checksum = checksum
+
+	// Machine readable output must not be mixed with progress messages
+	quiet := jsonOutput || openMetrics || oneline || binaryFile == "-" || pingCompat
+	if len(loadPathFile) > 0 {
+		config.Path, err = loadPath(loadPathFile, local, remote)
+		check(err)
+	}
+	// A cached path was selected with the same flags, so it is used like a loaded one
+	var cache pathCache
+	var cacheKey string
+	if len(pathCacheFile) > 0 {
+		cache, err = loadPathCache(pathCacheFile)
+		check(err)
+		cacheKey = pathCacheKey(local, remote, preferISD, avoidISD, maxHops, pathPolicyExpr)
+		config.Path = cache.lookup(cacheKey, time.Now())
+		if verbose && !quiet {
+			if config.Path != nil {
+				fmt.Println("Path cache: hit, expires", formatExpiry(config.Path.Path.Expiry()))
+			} else {
+				fmt.Println("Path cache: miss")
+			}
+		}
+	}
+	prober, err := probe.New(local, remote, config)
+	check(err)
+	printPath(prober, verbose, quiet)
+	if cache != nil && config.Path == nil {
+		check(cache.save(pathCacheFile, cacheKey, prober.Path(), time.Now()))
+	}
+	// Checked before any probe, so that a -size that does not fit fails or is clamped up front
+	var sizeLimit *probe.SizeLimit
+	if size > 0 && sweep == nil {
+		fit, err := prober.FitSize()
+		check(err)
+		if fit < size {
+			mtu := prober.MTU()
+			sizeLimit = &probe.SizeLimit{Requested: size, MTU: mtu, Effective: fit, Outcome: probe.SIZE_FAILED}
+			if !clampSize {
+				if jsonOutput {
+					out, err := json.MarshalIndent(map[string]interface{}{"size_limit": sizeLimit}, "", "  ")
+					check(err)
+					fmt.Println(string(out))
+				}
+				check(fmt.Errorf("Error, -size %d does not fit the path MTU of %d bytes, at most %d does, "+
+					"use -clamp-size to probe with it", size, mtu, fit))
+			}
+			if fit == 0 {
+				check(fmt.Errorf("Error, not even a probe without padding fits the path MTU of %d bytes", mtu))
+			}
+			sizeLimit.Outcome = probe.SIZE_CLAMPED
+			size = fit
+			prober.SetSize(fit)
+			if !quiet {
+				fmt.Printf("Clamped -size from %d to %d bytes, the path MTU is %d bytes\n", sizeLimit.Requested,
+					fit, mtu)
+			}
+		}
+	}
+	if (showBind || verbose || sourcePort > 0) && !quiet {
+		fmt.Println("Bound to:", Anon.text(prober.Bound()))
+	}
+	// The kernel may clamp the sizes, which is worth a warning but not worth giving up the run
+	if sndbuf > 0 || rcvbuf > 0 {
+		applied_snd, applied_rcv, err := prober.SetBufferSizes(sndbuf, rcvbuf)
+		if err != nil {
+			log.Printf("Warning, could not set socket buffer sizes: %v", err)
+		} else {
+			if verbose && !quiet {
+				fmt.Printf("Socket buffers: send %d bytes, receive %d bytes\n", applied_snd, applied_rcv)
+			}
+			if applied_snd < sndbuf || applied_rcv < rcvbuf {
+				log.Printf("Warning, socket buffers were clamped to send %d and receive %d bytes",
+					applied_snd, applied_rcv)
+			}
+		}
+	}
+
+	Seed = rand.NewSource(seed)
+
+	// The second destination gets its own socket on a random port, but shares the probe schedule
+	if len(interleaveAddress) > 0 {
+		remoteB, err := snet.AddrFromString(interleaveAddress)
+		check(err)
+		localB := local.Copy()
+		localB.L4Port = 0
+		proberB, err := probe.New(localB, remoteB, probe.Config{
+			Mode:       mode,
+			Timeout:    timeout,
+			Size:       size,
+			StrictSrc:  strictSrc,
+			ToBorder:   toBorder,
+			LooseId:    looseId,
+			MaxHops:    maxHops,
+			Policy:     policy,
+			PreferISD:  addr.ISD(preferISD),
+			AvoidISD:   addr.ISD(avoidISD),
+			Dispatcher: dispatcherAddr,
+			Clock:      clock,
+		})
+		check(err)
+		printPath(proberB, verbose, jsonOutput)
+		runInterleaved(prober, proberB, "A", "B", count, max_tries, interval, verbose, jsonOutput)
+		return
+	}
+
+	// The UDP probes take the path of the SCMP probes, so the difference is the responder's processing
+	if both {
+		localApp := local.Copy()
+		localApp.L4Port = 0
+		proberApp, err := probe.New(localApp, remote, probe.Config{
+			Mode:       probe.MODE_UDP,
+			Timeout:    timeout,
+			Size:       size,
+			StrictSrc:  strictSrc,
+			Path:       prober.Path(),
+			Dispatcher: dispatcherAddr,
+			Clock:      clock,
+		})
+		check(err)
+		printPath(proberApp, verbose, true)
+		runInterleaved(proberApp, prober, "Application (UDP)", "Network (SCMP)", count, max_tries, interval,
+			verbose, jsonOutput)
+		return
+	}
+
+	if voip {
+		result := runVoip(prober, duration, voipLoss, verbose)
+		if jsonOutput {
+			out, err := json.MarshalIndent(result, "", "  ")
+			check(err)
+			fmt.Println(string(out))
+		} else {
+			printVoip(result)
+		}
+		if result.Replies == 0 {
+			exit(EXIT_NO_REPLY)
+		}
+		return
+	}
+
+	if sweep != nil {
+		result := runSizeSweep(prober, sweep, count, interval, verbose)
+		if jsonOutput {
+			out, err := json.MarshalIndent(result, "", "  ")
+			check(err)
+			fmt.Println(string(out))
+		} else {
+			printSizeSweep(result)
+		}
+		return
+	}
+
+	if bidir {
+		result := runBidir(prober, count, interval, verbose)
+		if jsonOutput {
+			out, err := json.MarshalIndent(result, "", "  ")
+			check(err)
+			fmt.Println(string(out))
+		} else {
+			printBidir(result)
+		}
+		if result.Forward == nil {
+			exit(EXIT_NO_REPLY)
+		}
+		return
+	}
+
+	if perInterface {
+		hops := probePerInterface(prober, count, interval, verbose)
+		if jsonOutput {
+			out, err := json.MarshalIndent(hops, "", "  ")
+			check(err)
+			fmt.Println(string(out))
+		} else {
+			printPerInterface(hops)
+		}
+		return
+	}
+
+	var output *probeWriter
+	if len(outputPath) > 0 {
+		output, err = openProbeWriter(outputPath, outputFormat, compress, absTimes, rotate)
+		check(err)
+		exitHooks = append(exitHooks, func() { output.Close() })
+	}
+	var parquet *probe.ParquetWriter
+	if len(parquetPath) > 0 {
+		parquet, err = probe.OpenParquetWriter(parquetPath, "scion-latency version "+Version)
+		check(err)
+		exitHooks = append(exitHooks, func() { parquet.Close("") })
+	}
+	var capture *probe.CaptureWriter
+	if len(captureFile) > 0 {
+		linkType := uint16(probe.LINKTYPE_SCION)
+		if mode == probe.MODE_UDP {
+			linkType = probe.LINKTYPE_UDP_PAYLOAD
+		}
+		capture, err = probe.OpenCaptureWriter(captureFile, linkType)
+		check(err)
+		exitHooks = append(exitHooks, func() { capture.Close() })
+		prober.SetCapture(capture)
+	}
+
+	// Each path gets count probes, or the default number in continuous mode
+	var pathResults []*probe.PathResult
+	if allPaths {
+		probes := count
+		if probes == 0 {
+			probes = NUM_ITERS
+		}
+		pathResults = probeAllPaths(prober, probes, verbose)
+		reason := breakTie(pathResults, tieTolerance, tieBreak)
+		if verbose && !quiet {
+			progressf("Best path: %s\n", reason)
+		}
+		if pathResults[0].RTTAvg == nil {
+			log.Printf("Error, no reply received over any of the %d paths", len(pathResults))
+			exit(EXIT_NO_REPLY)
+		}
+		if !pinBest {
+			if jsonOutput {
+				out, err := json.MarshalIndent(pathResults, "", "  ")
+				check(err)
+				fmt.Println(string(out))
+			} else {
+				printPathRanking(pathResults)
+			}
+			return
+		}
+		check(prober.UsePath(pathResults[0].Entry))
+		if !quiet {
+			printPathRanking(pathResults)
+			fmt.Println("\nPinned to path:", pathResults[0].Path)
+		}
+	}
+
+	if len(savePathFile) > 0 {
+		check(savePath(savePathFile, prober.Path()))
+	}
+
+	// Read before measuring, a missing baseline should not cost a whole run
+	var baseline *probe.Result
+	if len(baselineFile) > 0 {
+		baseline, err = loadBaseline(baselineFile)
+		check(err)
+	}
+
+	// Calibrated before the measurement, so that its probes do not overlap with the echo requests
+	var overhead int64
+	if calibrateOverhead {
+		overhead, err = probe.Calibrate(local, config, NUM_ITERS)
+		check(err)
+		if verbose && !quiet {
+			fmt.Printf("Local overhead: %.3fms\n", float64(overhead)/1e6)
+		}
+	}
+
+	var pipe *pipeWriter
+	if len(pipePath) > 0 {
+		pipe, err = newPipeWriter(pipePath)
+		check(err)
+		defer pipe.Close()
+	}
+
+	// The warm-up ends just before the window, so the path state is fresh when measuring starts. Its
+	// probes are forgotten, a late reply to one must not count as a late reply of the measurement.
+	var scheduled time.Time
+	if len(measureAt) > 0 {
+		scheduled = nextTimeOfDay(measureClock, time.Now())
+		if !quiet {
+			fmt.Printf("Measuring from %s for %s\n", scheduled.Format(time.RFC3339), measureFor)
+		}
+		time.Sleep(time.Until(scheduled.Add(-WARMUP_LEAD)))
+		for i := 0; i < warmup; i++ {
+			if _, err := exchange(prober); err != nil && verbose {
+				progressf("Warm-up probe %d: %v\n", i+1, err)
+			}
+		}
+		prober.ResetStats()
+		time.Sleep(time.Until(scheduled))
+	}
+
+	var samples []probe.Sample
+	var windowSamples []probe.Sample
+	changes := &changeFilter{threshold: onChange}
+	var smallSamples []int64
+	var smallBytes, largeBytes int
+	var first_sent, last_received time.Time
+	var stability *probe.Stability
+	// With -with-load, the load average when each of the first max-samples-memory replies arrived
+	var loads, load_rtts []float64
+	// With -split-by-path, the first max-samples-memory samples grouped by reply path, in order of appearance
+	byPath := make(map[string][]probe.Sample)
+	var pathOrder []string
+	byPathKept := 0
+	// Timeouts are only analyzed for rate limiting in the first max-samples-memory probes
+	var outcomes []probeOutcome
+	// Scheduled from the send time of the previous probe, so the RTT does not shorten the gap
+	var next_send, last_sent time.Time
+	var series []*probe.SecondStats
+	var rtt_total int64
+	var responders probe.ResponderTally
+	live_status := oneline && isTerminal(os.Stdout)
+	run_start := time.Now()
+	// Ticks are numbered from run_start, the first probe is sent on tick 0 without waiting
+	var ticker *time.Ticker
+	last_tick, skipped_ticks := 0, 0
+	if pace > 0 {
+		ticker = time.NewTicker(pace)
+		defer ticker.Stop()
+	}
+	iters := 0
+	num_tries := 0
+	last_failed := false
+	budget_exhausted := false
+
+	// Stop probing on interrupt and summarize what was gathered
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	was_interrupted := false
+	if pingCompat {
+		fmt.Println(pingHeader(prober.Remote(), size))
+	}
+
+probing:
+	for count == 0 || (iters < count && num_tries < max_tries) {
+		select {
+		case <-interrupted:
+			was_interrupted = true
+			break probing
+		default:
+		}
+
+		// The carriage return overwrites the previous status, so the line must not shrink
+		if live_status && num_tries > 0 {
+			loss := 100 * float64(num_tries-iters) / float64(num_tries)
+			avg := 0.0
+			if iters > 0 {
+				avg = float64(rtt_total) / float64(iters) / 1e6
+			}
+			status := onelineStatus(destinationAddress, avg, loss, iters)
+			if sparkWidth > 0 {
+				status += " " + sparkline(windowSamples, sparkWidth)
+			}
+			fmt.Print("\r", status)
+		}
+
+		if isFlagSet("on-change") && num_tries > 0 && num_tries%CHANGE_WINDOW == 0 {
+			window := newWindowResult(sourceAddress, destinationAddress, windowSamples, CHANGE_WINDOW)
+			window.Mode = mode
+			if changes.changed(window) {
+				printChange(window, num_tries-CHANGE_WINDOW+1, num_tries, jsonOutput)
+			}
+			windowSamples = windowSamples[:0]
+		}
+
+		// Bytes are counted as written to the socket, so the budget may be overshot by one probe
+		if maxBytes > 0 && prober.Stats().BytesSent >= maxBytes {
+			budget_exhausted = true
+			if !quiet {
+				fmt.Printf("Stopping, byte budget of %d reached\n", maxBytes)
+			}
+			break
+		}
+
+		// A failed probe is followed by the retry back-off instead of the interval. The back-off
+		// is not part of any probe's timeout, so it only lengthens the total run time.
+		// With -pace the send times stay on the ticks, so there is no back-off. The ticker keeps at
+		// most one tick that fired while the previous probe was outstanding, it is dropped as stale.
+		if num_tries > 0 && pace > 0 {
+			select {
+			case <-ticker.C:
+			default:
+			}
+			tick := <-ticker.C
+			index := int((tick.Sub(run_start) + pace/2) / pace)
+			skipped_ticks += index - last_tick - 1
+			last_tick = index
+		} else if last_failed && retryDelay > 0 {
+			time.Sleep(jitteredDelay(retryDelay))
+		} else if num_tries > 0 && poissonRate > 0 {
+			time.Sleep(time.Until(next_send))
+		} else if num_tries > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		if duration > 0 && time.Since(run_start) >= duration {
+			break
+		}
+		num_tries += 1
+		last_failed = true
+
+		reply, err := exchange(prober)
+		if capture != nil {
+			check(capture.Err())
+		}
+		time_sent, time_received := reply.Sent, reply.Received
+		if first_sent.IsZero() {
+			first_sent = time_sent
+		}
+		last_sent = time_sent
+		if poissonRate > 0 {
+			next_send = time_sent.Add(time.Duration(randomExpFloat64() / poissonRate * float64(time.Second)))
+		}
+		if output != nil {
+			record := &ProbeRecord{Seq: num_tries}
+			if err != nil {
+				record.Error = Anon.text(err.Error())
+			} else {
+				rtt := float64(time_received.Sub(time_sent)) / 1e6
+				record.RTT = &rtt
+				record.Match = reply.Match
+			}
+			// A failed probe has no receive time
+			if absTimes {
+				sent := time_sent.UnixNano()
+				record.Sent = &sent
+				if err == nil {
+					received := time_received.UnixNano()
+					record.Received = &received
+				}
+			}
+			check(output.write(record))
+		}
+		if duration > 0 {
+			series = probe.AddToSeries(series, time_sent.Sub(run_start), int64(time_received.Sub(time_sent)),
+				err != nil)
+		}
+		if mode == probe.MODE_SCMP && !toBorder && len(outcomes) < maxSamplesMemory {
+			outcomes = append(outcomes, probeOutcome{time_sent.Sub(run_start), errors.Is(err, probe.ErrTimeout)})
+		}
+		if err != nil {
+			if verbose {
+				progressf("Probe %d: %v\n", num_tries, err)
+			}
+			continue
+		}
+		if verbose && absTimes {
+			fmt.Printf("Probe %d: sent %s, received %s\n", num_tries,
+				time_sent.Format(time.RFC3339Nano), time_received.Format(time.RFC3339Nano))
+		}
+
+		diff := int64(time_received.Sub(time_sent))
+		rtt_total += diff
+		if pingCompat {
+			fmt.Println(pingReply(reply.Source, reply.Bytes, num_tries, time.Duration(diff)))
+		}
+		if parquet != nil {
+			check(parquet.Write(probe.ParquetRow{Seq: int64(num_tries), Sent: time_sent.UnixNano(),
+				Received: time_received.UnixNano(), RTT: diff, Path: reply.Path}))
+		}
+		if count == 0 {
+			samples = addToReservoir(samples, newSample(num_tries, reply), iters, maxSamplesMemory)
+		} else {
+			samples = append(samples, newSample(num_tries, reply))
+		}
+		iters += 1
+		last_failed = false
+		last_received = time_received
+		windowSamples = append(windowSamples, newSample(num_tries, reply))
+		// -oneline excludes -on-change, so only the samples of the sparkline need to be kept
+		if live_status && len(windowSamples) > sparkWidth {
+			windowSamples = windowSamples[len(windowSamples)-sparkWidth:]
+		}
+		if trackResponders {
+			responders.Add(Anon.text(reply.Source), diff)
+		}
+		if splitByPath && byPathKept < maxSamplesMemory {
+			if _, ok := byPath[reply.Path]; !ok {
+				pathOrder = append(pathOrder, reply.Path)
+			}
+			byPath[reply.Path] = append(byPath[reply.Path], newSample(num_tries, reply))
+			byPathKept += 1
+		}
+		if withLoad && len(loads) < maxSamplesMemory {
+			load, err := readLoadAvg()
+			check(err)
+			loads = append(loads, load)
+			load_rtts = append(load_rtts, float64(diff)/1e6)
+		}
+		if pipe != nil {
+			pipe.write(num_tries, float64(diff)/1e6)
+		}
+
+		// The minimal probe right after the large one sees the same path conditions
+		if ttfb {
+			largeBytes = reply.Bytes
+			prober.SetSize(0)
+			small, err := exchange(prober)
+			prober.SetSize(size)
+			if err == nil {
+				smallSamples = append(smallSamples, int64(small.RTT()))
+				smallBytes = small.Bytes
+			} else if verbose {
+				progressf("Probe %d (minimal): %v\n", num_tries, err)
+			}
+		}
+		if untilStable && iters >= stableWindow {
+			mean, stddev := windowSpread(samples, stableWindow)
+			stability = &probe.Stability{Probes: num_tries, Window: stableWindow, RTTAvg: mean / 1e6,
+				StdDev: stddev / 1e6}
+			if stability.StdDev < stableStddev {
+				stability.Stable = true
+				break
+			}
+		}
+	}
+
+	run_elapsed := time.Since(run_start)
+	// The final status overwrites the live one, unless there is only an error to report
+	if live_status && num_tries > 0 {
+		if iters == 0 {
+			fmt.Println()
+		} else {
+			fmt.Print("\r")
+		}
+	}
+
+	if output != nil {
+		check(output.Close())
+	}
+	if capture != nil {
+		check(capture.Close())
+	}
+
+	// Like ping, the statistics are printed even if no or too few replies were received
+	if pingCompat {
+		printPingSummary(prober.Remote(), num_tries, iters, samples, run_elapsed)
+	}
+	if iters == 0 {
+		log.Printf("Error, no reply received from %s after %d attempts", destinationAddress, num_tries)
+		exit(EXIT_NO_REPLY)
+	}
+	stabilized := stability != nil && stability.Stable
+	if count != 0 && iters != count && !budget_exhausted && !was_interrupted && !stabilized {
+		check(probe.GaveUp(num_tries, iters, count))
+	}
+
+	result := newResult(sourceAddress, destinationAddress, samples)
+	result.Mode = mode
+	result.Paths = pathResults
+	// The weighted average is the headline one, everything derived from RTTAvg uses it
+	if decay > 0 {
+		unweighted := result.RTTAvg
+		result.RTTAvgUnweighted = &unweighted
+		result.RTTAvg = decayedMean(samples, decay) / 1e6
+		latency := result.RTTAvg / 2
+		result.Latency = &latency
+		result.DecayHalfLife = decay.Seconds()
+	}
+	if noOneway {
+		result.Latency = nil
+	}
+	if showBind || verbose || sourcePort > 0 {
+		result.Bound = Anon.text(prober.Bound())
+	}
+	result.ToBorder = toBorder
+	result.Samples = iters
+	loss := 100 * float64(num_tries-iters) / float64(num_tries)
+	result.Loss = &loss
+	counters := prober.Stats()
+	expiry := prober.PathExpiry()
+	result.PathExpiry = formatExpiry(expiry)
+	result.Size = size
+	result.SizeLimit = sizeLimit
+	result.MTU = prober.MTU()
+	if len(smallSamples) > 0 {
+		small, _ := probe.MeanAndStdErr(smallSamples)
+		smallAvg := small / 1e6
+		result.SmallRTTAvg = &smallAvg
+		if largeBytes > smallBytes {
+			// ms to us per byte
+			perByte := (result.RTTAvg - smallAvg) * 1e3 / float64(largeBytes-smallBytes)
+			result.PerByte = &perByte
+		}
+	}
+	result.BytesSent = counters.BytesSent
+	result.Duration = float64(last_received.Sub(first_sent)) / 1e6
+	result.Approximate = iters > len(samples)
+	// With -count 0 these are the samples of the reservoir, not all of them
+	if timestampsDetail {
+		result.SetTimestampsDetail(samples, maxJSONSamples)
+	}
+	if trim > 0 {
+		trimmed := trimmedMean(probe.RTTs(samples), trim) / 1e6
+		result.Trim = trim
+		result.RTTTrimmed = &trimmed
+	}
+	if result.Approximate {
+		// The reservoir no longer holds the samples in probe order
+		result.RTTTrend = nil
+	}
+	if duration > 0 {
+		probe.FinishSeries(series, run_elapsed)
+		result.Seconds = series
+	}
+	if len(measureAt) > 0 {
+		result.MeasuredWindow = &probe.MeasuredWindow{
+			Scheduled: scheduled.Format(time.RFC3339),
+			Start:     run_start.UTC().Format(time.RFC3339Nano),
+			End:       run_start.Add(run_elapsed).UTC().Format(time.RFC3339Nano),
+			Warmup:    warmup,
+		}
+	}
+	if emitCmdline {
+		result.Cmdline = Anon.text(normalizedCmdline(strings.Split(redactFlags, ",")))
+	}
+	if trackResponders {
+		result.Responders = responders.Stats(iters)
+		switches := responders.Switches()
+		result.Switches = &switches
+	}
+	result.Late = lateStats(counters.Late)
+	if checksum {
+		result.Corrupted = &counters.Corrupted
+	}
+	result.Extension = ext
+	result.Breaches = checkThresholds(result, maxRTT, maxLoss)
+	if len(sloSpec) > 0 {
+		result.SLO = compareToSLO(result, maxRTT, maxLoss)
+		met := result.SLO.Met()
+		result.SLOMet = &met
+	}
+	if ids != nil {
+		collisions := ids.Collisions()
+		result.IdCollisions = &collisions
+	}
+	if withLoad {
+		result.LoadCorrelation = correlation(loads, load_rtts)
+	}
+	for _, fingerprint := range pathOrder {
+		stats := newResult(sourceAddress, destinationAddress, byPath[fingerprint])
+		stats.Mode = mode
+		result.ReplyPaths = append(result.ReplyPaths, &probe.ReplyPathResult{Fingerprint: fingerprint, Stats: stats})
+	}
+	result.Stability = stability
+	result.Clock = clock
+	result.RateLimit = detectRateLimit(outcomes)
+	if poissonRate > 0 && num_tries > 1 {
+		achieved := float64(num_tries-1) / last_sent.Sub(first_sent).Seconds()
+		result.PoissonRate = &achieved
+	}
+	result.Seed = &seed
+	if pace > 0 {
+		result.SkippedTicks = &skipped_ticks
+	}
+	if baseline != nil {
+		result.Baseline = compareToBaseline(result, baseline, baselineFile, regressThreshold)
+	}
+	if calibrateOverhead {
+		ms := float64(overhead) / 1e6
+		result.Overhead = &ms
+		if subtractOverhead {
+			result.Adjusted = &probe.AdjustedRTT{
+				RTTAvg: result.RTTAvg - ms,
+				RTTMin: result.RTTMin - ms,
+				RTTMax: result.RTTMax - ms,
+			}
+		}
+	}
+	if parquet != nil {
+		summary, err := json.Marshal(result)
+		check(err)
+		check(parquet.Close(string(summary)))
+	}
+	if len(binaryFile) > 0 {
+		data, err := probe.EncodeBinary(result)
+		check(err)
+		if binaryFile == "-" {
+			_, err = os.Stdout.Write(data)
+		} else {
+			err = ioutil.WriteFile(binaryFile, data, 0644)
+		}
+		check(err)
+	}
+	if binaryFile == "-" {
+		// The binary result is all that is written to stdout
+	} else if jsonOutput {
+		check(printJSON(result))
+	} else if openMetrics {
+		var histogram []probe.Sample
+		if exemplars {
+			histogram = samples
+		}
+		check(probe.WriteOpenMetrics(os.Stdout, result, time.Now(), histogram))
+	} else if pingCompat {
+		// The statistics were printed right after probing
+	} else if oneline {
+		// Keeps the sparkline, the final status overwrites the live one and must not be shorter
+		status := onelineStatus(destinationAddress, result.RTTAvg, *result.Loss, result.Samples)
+		if live_status && sparkWidth > 0 {
+			status += " " + sparkline(windowSamples, sparkWidth)
+		}
+		fmt.Println(status)
+	} else {
+		if duration > 0 {
+			printSeries(series)
+		}
+		printSummary(result)
+		if result.Baseline != nil {
+			printComparison(result.Baseline)
+		}
+		if result.SLO != nil {
+			printSLO(result.SLO)
+		}
+		if emitCmdline {
+			fmt.Println("# " + result.Cmdline)
+		}
+		if verbose {
+			if counters.Discarded > 0 {
+				fmt.Printf("Discarded %d packets that did not match a probe\n", counters.Discarded)
+			}
+			if !expiry.IsZero() {
+				fmt.Printf("Path expires: %s (in %s)\n", result.PathExpiry, time.Until(expiry).Round(time.Second))
+			}
+			printHistogram(probe.RTTs(samples), histBins)
+		}
+	}
+
+	// Syslog, StatsD and Grafana are best effort, the measurement itself succeeded
+	if useSyslog {
+		if err := sendToSyslog(syslogAddr, result); err != nil {
+			log.Printf("Warning, could not write to syslog: %v", err)
+		}
+	}
+	if len(statsdAddr) > 0 {
+		if err := sendToStatsd(statsdAddr, result, statsdTags); err != nil {
+			log.Printf("Warning, could not send to StatsD: %v", err)
+		}
+	}
+	if len(grafanaURL) > 0 && len(result.Breaches) > 0 {
+		if err := annotateGrafana(grafanaURL, grafanaToken, result); err != nil {
+			log.Printf("Warning, could not post the Grafana annotation: %v", err)
+		}
+	}
+
+	if len(execCommand) > 0 {
+		check(execWithResult(execCommand, result))
+	}
+
+	if result.Baseline != nil && result.Baseline.Regression {
+		exit(EXIT_REGRESSION)
+	}
+	if result.SLOMet != nil && !*result.SLOMet {
+		exit(EXIT_SLO_MISSED)
+	}
+}
@@ -3,14 +3,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/MdBaizil/scion-homeworks/latency/probe"
 	"github.com/scionproto/scion/go/lib/snet"
 	"github.com/scionproto/scion/go/lib/sciond"
 )
 
+// Probes still unanswered this long after the last one was sent are reported as lost. The
+// messages of -bidir and the limits of a START are those of the probe package, see probe.BIDIR_LEN.
+const BIDIR_LINGER = 2 * time.Second
+
 func check(e error) {
 	if e != nil {
 		log.Fatal(e)
@@ -18,16 +27,114 @@ func check(e error) {
 }
 
 func printUsage() {
-	fmt.Println("\ndataplane_server -s ServerSCIONAddress")
+	fmt.Println("\ndataplane_server -s ServerSCIONAddress [-bidir]")
 	fmt.Println("\tListens for incoming connections and responds back to them right away")
+	fmt.Println("\tWith -bidir it also probes clients that ask for it (controlplane_client -bidir)")
 	fmt.Println("\tThe SCION address is specified as ISD-AS,[IP Address]:Port")
 	fmt.Println("\tIf server listening port unspecified, a random available one will be used")
 	fmt.Println("\tExample SCION address 1-1,[127.0.0.1]:42002\n")
 }
 
+// Probes of the server to one -bidir client and when they were sent
+type bidirPeer struct {
+	mu   sync.Mutex
+	sent map[uint32]time.Time
+}
+
+// Replies and probes of all clients are written from several goroutines
+type bidirServer struct {
+	conn    *snet.Conn
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	peers   map[string]*bidirPeer
+}
+
+func (b *bidirServer) send(client *snet.Addr, msgType byte, seq uint32, value int64) {
+	msg := make([]byte, probe.BIDIR_LEN)
+	copy(msg, probe.BIDIR_MAGIC)
+	msg[4] = msgType
+	binary.BigEndian.PutUint32(msg[5:], seq)
+	binary.BigEndian.PutUint64(msg[9:], uint64(value))
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	_, err := b.conn.WriteTo(msg, client)
+	if err != nil {
+		log.Printf("Warning, could not write to %s: %v", client, err)
+	}
+}
+
+func (b *bidirServer) handle(msg []byte, client *snet.Addr) {
+	seq := binary.BigEndian.Uint32(msg[5:])
+	value := int64(binary.BigEndian.Uint64(msg[9:]))
+	switch msg[4] {
+	case probe.BIDIR_START:
+		count := int(seq)
+		if count > probe.BIDIR_MAX_COUNT {
+			count = probe.BIDIR_MAX_COUNT
+		}
+		interval := time.Duration(value)
+		if interval < probe.BIDIR_MIN_INTERVAL {
+			interval = probe.BIDIR_MIN_INTERVAL
+		}
+		peer := &bidirPeer{sent: make(map[uint32]time.Time)}
+		b.mu.Lock()
+		_, running := b.peers[client.String()]
+		if !running {
+			b.peers[client.String()] = peer
+		}
+		b.mu.Unlock()
+		if running {
+			log.Printf("Warning, ignoring START from %s, it is already being probed", client)
+			return
+		}
+		go b.probe(client.Copy(), peer, count, interval)
+	case probe.BIDIR_PROBE:
+		b.send(client, probe.BIDIR_ECHO, seq, 0)
+	case probe.BIDIR_ECHO:
+		b.mu.Lock()
+		peer := b.peers[client.String()]
+		b.mu.Unlock()
+		if peer == nil {
+			return
+		}
+		peer.mu.Lock()
+		sent, ok := peer.sent[seq]
+		delete(peer.sent, seq)
+		peer.mu.Unlock()
+		if ok {
+			b.send(client, probe.BIDIR_REPORT, seq, int64(time.Since(sent)))
+		}
+	}
+}
+
+// Sends count probes to the client every interval, then reports the ones left unanswered as lost.
+// The client stays in peers until then, so a second START meanwhile is ignored.
+func (b *bidirServer) probe(client *snet.Addr, peer *bidirPeer, count int, interval time.Duration) {
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			time.Sleep(interval)
+		}
+		peer.mu.Lock()
+		peer.sent[uint32(seq)] = time.Now()
+		peer.mu.Unlock()
+		b.send(client, probe.BIDIR_PROBE, uint32(seq), 0)
+	}
+	time.Sleep(BIDIR_LINGER)
+
+	b.mu.Lock()
+	delete(b.peers, client.String())
+	b.mu.Unlock()
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	for seq := range peer.sent {
+		b.send(client, probe.BIDIR_REPORT, seq, -1)
+	}
+}
+
 func main() {
 	var (
 		serverAddress string
+		bidir bool
 
 		err    error
 		server *snet.Addr
@@ -37,6 +144,7 @@ func main() {
 
 	// Fetch arguments from command line
 	flag.StringVar(&serverAddress, "s", "", "Server SCION Address")
+	flag.BoolVar(&bidir, "bidir", false, "Probe clients that ask for it while answering their probes")
 	flag.Parse()
 
 	// Create the SCION UDP socket
@@ -53,16 +161,24 @@ func main() {
 
 	udpConnection, err = snet.ListenSCION("udp4", server)
 	check(err)
+	responder := &bidirServer{conn: udpConnection, peers: make(map[string]*bidirPeer)}
 
 	receivePacketBuffer := make([]byte, 2500)
 	for {
 		n, clientAddress, err := udpConnection.ReadFrom(receivePacketBuffer)
 		check(err)
 
+		msg := receivePacketBuffer[:n]
+		if bidir && n == probe.BIDIR_LEN && bytes.HasPrefix(msg, []byte(probe.BIDIR_MAGIC)) {
+			responder.handle(msg, clientAddress.(*snet.Addr))
+			continue
+		}
+
 		// Packet received, send back response to same client
-		_, err = udpConnection.WriteTo(receivePacketBuffer[:n], clientAddress)
+		responder.writeMu.Lock()
+		_, err = udpConnection.WriteTo(msg, clientAddress)
+		responder.writeMu.Unlock()
 		check(err)
 		fmt.Println("Received connection from", clientAddress)
 	}
 }
-
@@ -0,0 +1,165 @@
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Messages of the bidirectional protocol with dataplane_server -bidir. Every message is BIDIR_LEN
+// bytes: the magic, the type, a big-endian uint32 sequence number and an int64 value.
+//
+//	client                              server
+//	START(count, interval)  ------->    starts probing the client
+//	PROBE(seq)              ------->    ECHO(seq) right away
+//	ECHO(seq)               <-------    PROBE(seq), answered right away
+//	                        <-------    REPORT(seq, RTT in ns of the server's probe seq, -1 if lost)
+//
+// Both sides send probe seq at about seq intervals after the START, so the two RTT series are
+// aligned by sequence number; they are offset by the one-way delay of the START.
+const (
+	BIDIR_MAGIC = "BDIR"
+	BIDIR_LEN = 17
+	BIDIR_START = 1
+	BIDIR_PROBE = 2
+	BIDIR_ECHO = 3
+	BIDIR_REPORT = 4
+	// The server reports its lost probes this long after its last one, see BIDIR_LINGER there
+	BIDIR_WAIT = 3 * time.Second
+	// The server sends at most this many probes and raises shorter intervals to the minimum, so that
+	// a START, which any host can send, cannot turn it into a flood source
+	BIDIR_MAX_COUNT = 10000
+	BIDIR_MIN_INTERVAL = 10 * time.Millisecond
+)
+
+// RTTs (in ns) of Bidir by sequence number, 0 for a lost probe: Forward of the probes sent to the
+// responder, Reverse of the responder's probes as it reported them
+type BidirSeries struct {
+	Sent     []time.Time
+	Forward  []int64
+	Reverse  []int64
+	Reported int // Reports received, fewer than the probes if the responder did not send them in time
+	Failed   int // Probes that could not be written, they count as lost
+}
+
+func bidirMessage(msgType byte, seq uint32, value int64) []byte {
+	msg := make([]byte, BIDIR_LEN)
+	copy(msg, BIDIR_MAGIC)
+	msg[4] = msgType
+	binary.BigEndian.PutUint32(msg[5:], seq)
+	binary.BigEndian.PutUint64(msg[9:], uint64(value))
+	return msg
+}
+
+// Probes the UDP responder while it probes back over the same socket, count probes each interval
+// apart. The interval is raised to BIDIR_MIN_INTERVAL like the responder does, to keep both series
+// aligned. A goroutine answers the responder's probes and collects the replies and reports while
+// the probes are sent, writes to the socket are serialized with writeMu.
+func (p *Prober) Bidir(count int, interval time.Duration) (BidirSeries, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.sess
+	if p.closed {
+		return BidirSeries{}, fmt.Errorf("Error, probe on a closed Prober")
+	}
+	if s.mode != MODE_UDP {
+		return BidirSeries{}, fmt.Errorf("Error, bidirectional probing requires mode %s", MODE_UDP)
+	}
+	if count < 1 || count > BIDIR_MAX_COUNT {
+		return BidirSeries{}, fmt.Errorf("Error, the responder sends between 1 and %d probes, not %d",
+			BIDIR_MAX_COUNT, count)
+	}
+	if interval < BIDIR_MIN_INTERVAL {
+		interval = BIDIR_MIN_INTERVAL
+	}
+	var mu, writeMu sync.Mutex
+	series := BidirSeries{
+		Sent:    make([]time.Time, count),
+		Forward: make([]int64, count),
+		Reverse: make([]int64, count),
+	}
+	allReported := make(chan struct{})
+
+	write := func(msg []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := s.udpConn.WriteToSCION(msg, s.remote)
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, BIDIR_LEN+1)
+		for {
+			n, _, err := s.udpConn.ReadFromSCION(buf)
+			if err != nil {
+				// The deadline set below ends the reader
+				return
+			}
+			if n != BIDIR_LEN || string(buf[:4]) != BIDIR_MAGIC {
+				mu.Lock()
+				s.discarded += 1
+				mu.Unlock()
+				continue
+			}
+			seq := binary.BigEndian.Uint32(buf[5:])
+			value := int64(binary.BigEndian.Uint64(buf[9:]))
+			if int(seq) >= count {
+				mu.Lock()
+				s.discarded += 1
+				mu.Unlock()
+				continue
+			}
+			switch buf[4] {
+			case BIDIR_PROBE:
+				write(bidirMessage(BIDIR_ECHO, seq, 0))
+			case BIDIR_ECHO:
+				mu.Lock()
+				if !series.Sent[seq].IsZero() && series.Forward[seq] == 0 {
+					series.Forward[seq] = int64(now(s.clock).Sub(series.Sent[seq]))
+				}
+				mu.Unlock()
+			case BIDIR_REPORT:
+				mu.Lock()
+				if series.Reverse[seq] == 0 {
+					series.Reverse[seq] = value
+					series.Reported += 1
+					if series.Reported == count {
+						close(allReported)
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	s.udpConn.SetReadDeadline(time.Time{})
+	if err := write(bidirMessage(BIDIR_START, uint32(count), int64(interval))); err != nil {
+		s.udpConn.SetReadDeadline(time.Now())
+		<-done
+		return BidirSeries{}, err
+	}
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			time.Sleep(interval)
+		}
+		mu.Lock()
+		series.Sent[seq] = now(s.clock)
+		mu.Unlock()
+		if err := write(bidirMessage(BIDIR_PROBE, uint32(seq), 0)); err != nil {
+			series.Failed += 1
+			continue
+		}
+		s.bytesSent += BIDIR_LEN
+	}
+	select {
+	case <-allReported:
+	case <-time.After(BIDIR_WAIT + s.timeout):
+	}
+	s.udpConn.SetReadDeadline(time.Now())
+	// The reader has stopped, the series is no longer written
+	<-done
+	return series, nil
+}
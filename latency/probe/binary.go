@@ -0,0 +1,149 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Compact result of EncodeBinary, as the command writes it with -binary. All fields are big-endian,
+// statistics are IEEE 754 doubles in ms and NaN where the JSON result has null. The 76 byte header
+// is followed by the source and the destination address, each as a 16 bit length and that many
+// bytes of UTF-8.
+//
+//	offset  width  field
+//	0       4      magic "SLAT"
+//	4       1      version, 1
+//	5       1      mode, 0 for scmp and 1 for udp
+//	6       2      flags, bit 0 to_border, bit 1 approximate
+//	8       4      samples
+//	12      8      bytes_sent
+//	20      8      duration
+//	28      8      rtt_avg
+//	36      8      rtt_min
+//	44      8      rtt_max
+//	52      8      stderr
+//	60      8      ci95
+//	68      8      loss (percent)
+type binaryHeader struct {
+	Magic     [4]byte
+	Version   uint8
+	Mode      uint8
+	Flags     uint16
+	Samples   uint32
+	BytesSent uint64
+	Duration  float64
+	RTTAvg    float64
+	RTTMin    float64
+	RTTMax    float64
+	StdErr    float64
+	CI95      float64
+	Loss      float64
+}
+
+const (
+	BINARY_VERSION = 1
+	BINARY_TO_BORDER = 1 << 0
+	BINARY_APPROXIMATE = 1 << 1
+)
+
+var BINARY_MAGIC = [4]byte{'S', 'L', 'A', 'T'}
+
+func nanIfNil(f *float64) float64 {
+	if f == nil {
+		return math.NaN()
+	}
+	return *f
+}
+
+func nilIfNaN(f float64) *float64 {
+	if math.IsNaN(f) {
+		return nil
+	}
+	return &f
+}
+
+// Encodes the result in the binary layout of binaryHeader, as written with -binary
+func EncodeBinary(result *Result) ([]byte, error) {
+	if len(result.Source) > math.MaxUint16 || len(result.Destination) > math.MaxUint16 {
+		return nil, fmt.Errorf("Error, address too long for the binary result")
+	}
+	header := binaryHeader{
+		Magic:     BINARY_MAGIC,
+		Version:   BINARY_VERSION,
+		Samples:   uint32(result.Samples),
+		BytesSent: uint64(result.BytesSent),
+		Duration:  result.Duration,
+		RTTAvg:    result.RTTAvg,
+		RTTMin:    result.RTTMin,
+		RTTMax:    result.RTTMax,
+		StdErr:    nanIfNil(result.StdErr),
+		CI95:      nanIfNil(result.CI95),
+		Loss:      nanIfNil(result.Loss),
+	}
+	if result.Mode == MODE_UDP {
+		header.Mode = 1
+	}
+	if result.ToBorder {
+		header.Flags |= BINARY_TO_BORDER
+	}
+	if result.Approximate {
+		header.Flags |= BINARY_APPROXIMATE
+	}
+	out := new(bytes.Buffer)
+	binary.Write(out, binary.BigEndian, &header)
+	for _, address := range []string{result.Source, result.Destination} {
+		binary.Write(out, binary.BigEndian, uint16(len(address)))
+		out.WriteString(address)
+	}
+	return out.Bytes(), nil
+}
+
+// Decodes a result written by EncodeBinary, for programs that embed it. Only the fields of the
+// binary layout are set.
+func DecodeBinary(data []byte) (*Result, error) {
+	in := bytes.NewReader(data)
+	var header binaryHeader
+	if err := binary.Read(in, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("Error, truncated binary result: %v", err)
+	}
+	if header.Magic != BINARY_MAGIC || header.Version != BINARY_VERSION {
+		return nil, fmt.Errorf("Error, not a version %d binary result", BINARY_VERSION)
+	}
+	var addresses [2]string
+	for i := range addresses {
+		var length uint16
+		if err := binary.Read(in, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("Error, truncated binary result: %v", err)
+		}
+		address := make([]byte, length)
+		if _, err := io.ReadFull(in, address); err != nil {
+			return nil, fmt.Errorf("Error, truncated binary result: %v", err)
+		}
+		addresses[i] = string(address)
+	}
+
+	result := &Result{
+		Source:      addresses[0],
+		Destination: addresses[1],
+		Mode:        MODE_SCMP,
+		ToBorder:    header.Flags&BINARY_TO_BORDER != 0,
+		Samples:     int(header.Samples),
+		BytesSent:   int64(header.BytesSent),
+		Duration:    header.Duration,
+		Approximate: header.Flags&BINARY_APPROXIMATE != 0,
+		RTTAvg:      header.RTTAvg,
+		RTTMin:      header.RTTMin,
+		RTTMax:      header.RTTMax,
+		Range:       header.RTTMax - header.RTTMin,
+		StdErr:      nilIfNaN(header.StdErr),
+		CI95:        nilIfNaN(header.CI95),
+		Loss:        nilIfNaN(header.Loss),
+	}
+	if header.Mode == 1 {
+		result.Mode = MODE_UDP
+	}
+	return result, nil
+}
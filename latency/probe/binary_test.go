@@ -0,0 +1,35 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	stdErr, loss := 0.25, 12.5
+	result := &Result{Source: "1-ff00:0:1,[10.0.0.1]", Destination: "1-ff00:0:2,[10.0.0.2]", Mode: MODE_UDP,
+		ToBorder: true, Samples: 8, BytesSent: 896, Duration: 7012.5, RTTAvg: 12.5, RTTMin: 10, RTTMax: 20,
+		Range: 10, StdErr: &stdErr, Loss: &loss}
+	data, err := EncodeBinary(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 76 + 2 + len(result.Source) + 2 + len(result.Destination); len(data) != want {
+		t.Errorf("encoded %d bytes, want %d", len(data), want)
+	}
+	decoded, err := DecodeBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CI95 was not set, it is NaN in the encoding and nil again when decoded
+	if !reflect.DeepEqual(decoded, result) {
+		t.Errorf("DecodeBinary(EncodeBinary(%+v)) = %+v", result, decoded)
+	}
+	if _, err := DecodeBinary(data[:len(data)-1]); err == nil {
+		t.Errorf("DecodeBinary accepted a truncated result")
+	}
+	data[0] = 'X'
+	if _, err := DecodeBinary(data); err == nil {
+		t.Errorf("DecodeBinary accepted a result with a bad magic")
+	}
+}
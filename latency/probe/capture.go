@@ -0,0 +1,84 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// Link types of a capture from the range reserved for private use, Wireshark decodes them once
+// DLT_USER 0 is mapped to the SCION dissector
+const (
+	LINKTYPE_SCION = 147      // Whole SCION packets, as exchanged with the dispatcher with MODE_SCMP
+	LINKTYPE_UDP_PAYLOAD = 148 // UDP payloads only with MODE_UDP, snet does not expose the headers
+)
+
+// Writes the probe packets sent and received to a pcapng file: a section header block, one
+// interface description block with nanosecond timestamps, and an enhanced packet block per packet
+// whose flags option marks it inbound (1) or outbound (2)
+type CaptureWriter struct {
+	file *os.File
+	out  *bufio.Writer
+}
+
+// Creates the capture file at path and writes its header
+func OpenCaptureWriter(path string, linkType uint16) (*CaptureWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &CaptureWriter{file: file, out: bufio.NewWriter(file)}
+	// Section header: byte-order magic, version 1.0 and unknown section length
+	w.block(0x0A0D0D0A, []interface{}{uint32(0x1A2B3C4D), uint16(1), uint16(0), int64(-1)})
+	// Interface description: link type, reserved, snap length and if_tsresol = 10^-9 s
+	w.block(1, []interface{}{linkType, uint16(0), uint32(0), uint16(9), uint16(1), [4]byte{9},
+		uint16(0), uint16(0)})
+	return w, w.Err()
+}
+
+// The first error of the writes so far, bufio keeps it until the next flush. A Prober ignores the
+// errors of its writes, so that a full disk does not fail the probes, callers check Err instead.
+func (w *CaptureWriter) Err() error {
+	_, err := w.out.Write(nil)
+	return err
+}
+
+// Writes a block of type blockType with the body fields, little endian and padded to 32 bits
+func (w *CaptureWriter) block(blockType uint32, fields []interface{}) {
+	body := new(bytes.Buffer)
+	for _, field := range fields {
+		binary.Write(body, binary.LittleEndian, field)
+	}
+	for body.Len()%4 != 0 {
+		body.WriteByte(0)
+	}
+	length := uint32(body.Len() + 12)
+	binary.Write(w.out, binary.LittleEndian, blockType)
+	binary.Write(w.out, binary.LittleEndian, length)
+	w.out.Write(body.Bytes())
+	binary.Write(w.out, binary.LittleEndian, length)
+}
+
+// Writes a packet sent (outbound) or received (inbound) at t
+func (w *CaptureWriter) Write(t time.Time, inbound bool, packet []byte) error {
+	flags := uint32(2)
+	if inbound {
+		flags = 1
+	}
+	ts := uint64(t.UnixNano())
+	padded := make([]byte, (len(packet)+3)/4*4)
+	copy(padded, packet)
+	w.block(6, []interface{}{uint32(0), uint32(ts >> 32), uint32(ts), uint32(len(packet)), uint32(len(packet)),
+		padded, uint16(2), uint16(4), flags, uint16(0), uint16(0)})
+	return w.Err()
+}
+
+func (w *CaptureWriter) Close() error {
+	err := w.out.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package probe
+
+import (
+	"errors"
+	"net"
+
+	"github.com/scionproto/scion/go/lib/common"
+)
+
+// Kinds of measurement errors, a MeasurementError reports itself as its kind to errors.Is
+var (
+	ErrNoPath    = errors.New("no path to the destination")
+	ErrTimeout   = errors.New("no reply before the timeout")
+	ErrTruncated = errors.New("truncated reply")
+	ErrSCMPError = errors.New("SCMP error in reply to a probe")
+	ErrMaxTries  = errors.New("exceeded maximum number of attempts")
+	ErrTooLarge  = errors.New("probe does not fit the path MTU")
+)
+
+// Error of kind Kind, with the detail of what went wrong in Err
+type MeasurementError struct {
+	Kind error
+	Err  error
+}
+
+func (e *MeasurementError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Err.Error()
+}
+
+func (e *MeasurementError) Is(target error) bool { return target == e.Kind }
+
+func (e *MeasurementError) Unwrap() error { return e.Err }
+
+// Error for giving up after tries probes with replies to fewer than the count asked for
+func GaveUp(tries int, replies int, count int) error {
+	return &MeasurementError{Kind: ErrMaxTries, Err: common.NewBasicError("Error, exceeded maximum number of attempts",
+		nil, "tries", tries, "replies", replies, "count", count)}
+}
+
+// A read that ran into the probe's deadline means the reply did not arrive in time
+func readError(err error) error {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return &MeasurementError{Kind: ErrTimeout, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/scionproto/scion/go/lib/common"
+	"github.com/scionproto/scion/go/lib/sciond"
+	"github.com/scionproto/scion/go/lib/scmp"
+	"github.com/scionproto/scion/go/lib/spath/spathmeta"
+	"github.com/scionproto/scion/go/lib/spkt"
+)
+
+var kinds = []error{ErrNoPath, ErrTimeout, ErrTruncated, ErrSCMPError, ErrMaxTries, ErrTooLarge}
+
+// Fails unless err is a MeasurementError of kind, and of no other kind
+func checkKind(t *testing.T, err error, kind error) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected an error of kind %q, got none", kind)
+	}
+	for _, other := range kinds {
+		if got := errors.Is(err, other); got != (other == kind) {
+			t.Errorf("errors.Is(%q, %q) = %v", err, other, got)
+		}
+	}
+	var merr *MeasurementError
+	if !errors.As(err, &merr) || merr.Kind != kind {
+		t.Errorf("errors.As(%q) did not yield a MeasurementError of kind %q", err, kind)
+	}
+}
+
+// Paths with the given numbers of interface hops
+func testPaths(hops ...int) spathmeta.AppPathSet {
+	paths := make(spathmeta.AppPathSet)
+	for i, n := range hops {
+		entry := &sciond.PathReplyEntry{Path: &sciond.FwdPathMeta{Interfaces: make([]sciond.PathInterface, n)}}
+		paths[spathmeta.PathKey(fmt.Sprint(i))] = &spathmeta.AppPath{Entry: entry}
+	}
+	return paths
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestMeasurementErrorWrapsCause(t *testing.T) {
+	cause := errors.New("read failed")
+	err := &MeasurementError{Kind: ErrTimeout, Err: cause}
+	if !errors.Is(err, cause) {
+		t.Errorf("errors.Is(%q, cause) = false", err)
+	}
+	if err.Error() != cause.Error() {
+		t.Errorf("Error() = %q, want the cause %q", err.Error(), cause.Error())
+	}
+	if got := (&MeasurementError{Kind: ErrTimeout}).Error(); got != ErrTimeout.Error() {
+		t.Errorf("Error() without a cause = %q, want the kind %q", got, ErrTimeout.Error())
+	}
+}
+
+func TestNoPathMaxHops(t *testing.T) {
+	_, err := filterMaxHops(testPaths(4, 6), 3)
+	checkKind(t, err, ErrNoPath)
+	if kept, err := filterMaxHops(testPaths(4, 6), 4); err != nil || len(kept) != 1 {
+		t.Errorf("filterMaxHops kept %d paths with error %v, want 1 path", len(kept), err)
+	}
+}
+
+func TestNoPathPolicy(t *testing.T) {
+	policy, err := ParsePathPolicy("hops<=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = policy.filter(testPaths(4, 6))
+	checkKind(t, err, ErrNoPath)
+}
+
+func TestTimeout(t *testing.T) {
+	checkKind(t, readError(timeoutError{}), ErrTimeout)
+	other := errors.New("connection refused")
+	if err := readError(other); err != other {
+		t.Errorf("readError(%q) = %q, want it unchanged", other, err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	checkKind(t, checkTruncated(make(common.RawBytes, spkt.CmnHdrLen-1)), ErrTruncated)
+}
+
+func TestSCMPError(t *testing.T) {
+	s := &session{seq: 3}
+	pkt := scmpErrorQuoting(1, 3, 0, scmp.HdrLen+scmp.MetaLen+(&scmp.InfoEcho{}).Len())
+	checkKind(t, s.matchSCMP(pkt, 1), ErrSCMPError)
+}
+
+func TestMaxTries(t *testing.T) {
+	checkKind(t, GaveUp(10, 3, 5), ErrMaxTries)
+}
+
+func TestTooLarge(t *testing.T) {
+	s := &session{mode: MODE_UDP, size: 1500, mtu: 1472, sendBuff: make(common.RawBytes, 1500)}
+	_, _, err := s.probeUDP()
+	checkKind(t, err, ErrTooLarge)
+}
@@ -0,0 +1,98 @@
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Guards rng, a rand.Source must not be used by several goroutines at once
+var rngMutex sync.Mutex
+
+func randomUint64() uint64 {
+	rngMutex.Lock()
+	defer rngMutex.Unlock()
+	return rng.Uint64()
+}
+
+// Fills b with random bytes, so that a bit flipped either way changes its checksum
+func fillRandom(b []byte) {
+	for i := 0; i < len(b); i += 8 {
+		var word [8]byte
+		binary.LittleEndian.PutUint64(word[:], randomUint64())
+		copy(b[i:], word[:])
+	}
+}
+
+// Ids an IdRange remembers to count collisions: between this many and twice as many of the last
+// drawn. That is over a minute of probes 1 ms apart, by when an earlier probe is past LATE_HORIZON
+// and a collision with its Id can no longer mix up replies.
+const IDS_REMEMBERED = 1 << 16
+
+// Range of echo request Ids, so that concurrent probers do not collide. An Id drawn twice is
+// counted as a collision, the range is too small for the run. Probers may share a range.
+type IdRange struct {
+	lo, hi     uint64
+	mu         sync.Mutex
+	used       map[uint64]bool // Ids drawn, at most IDS_REMEMBERED
+	previous   map[uint64]bool // The generation of used before it filled up
+	collisions int
+}
+
+// Parses a range given as LO-HI, both included
+func ParseIdRange(s string) (*IdRange, error) {
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Error, invalid Id range %q, expected LO-HI", s)
+	}
+	lo, errLo := strconv.ParseUint(bounds[0], 10, 64)
+	hi, errHi := strconv.ParseUint(bounds[1], 10, 64)
+	if errLo != nil || errHi != nil {
+		return nil, fmt.Errorf("Error, Id range %q must be two 64 bit unsigned integers", s)
+	}
+	if lo > hi {
+		return nil, fmt.Errorf("Error, Id range %q is empty", s)
+	}
+	return &IdRange{lo: lo, hi: hi, used: make(map[uint64]bool)}, nil
+}
+
+// Draws an Id uniformly from the range with src, or the package's own source if nil. src is only
+// used under the range's lock, so it may be shared by the probers that share the range.
+func (r *IdRange) draw(src rand.Source) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next := randomUint64
+	if src != nil {
+		next = rand.New(src).Uint64
+	}
+	id := next()
+	// The width overflows to 0 for the whole 64 bit range
+	if width := r.hi - r.lo + 1; width != 0 {
+		// The 2^64 mod width lowest values would make id%width favour the low Ids, they are drawn again
+		for id < -width%width {
+			id = next()
+		}
+		id = r.lo + id%width
+	}
+	if r.used[id] || r.previous[id] {
+		r.collisions += 1
+	}
+	if len(r.used) == IDS_REMEMBERED {
+		r.previous, r.used = r.used, make(map[uint64]bool)
+	}
+	r.used[id] = true
+	return id
+}
+
+// Number of Ids drawn that had been drawn before
+func (r *IdRange) Collisions() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.collisions
+}
@@ -0,0 +1,74 @@
+package probe
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// Run with -race, the detector flags unguarded use of rng or of a shared IdRange
+func TestIdsFromManyGoroutines(t *testing.T) {
+	const goroutines, perGoroutine = 32, 1000
+	ids, err := ParseIdRange("1000-1999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				randomUint64()
+				if id := ids.draw(nil); id < 1000 || id > 1999 {
+					t.Errorf("drew Id %d outside of 1000-1999", id)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// Every draw beyond the first of each of the 1000 Ids is a collision
+	if got, want := ids.Collisions(), goroutines*perGoroutine-1000; got != want {
+		t.Errorf("Collisions() = %d, want %d", got, want)
+	}
+}
+
+func TestParseIdRange(t *testing.T) {
+	for _, s := range []string{"5", "9-1", "a-5", "1-b", "-1-5"} {
+		if _, err := ParseIdRange(s); err == nil {
+			t.Errorf("ParseIdRange(%q) succeeded, want an error", s)
+		}
+	}
+	r, err := ParseIdRange("0-18446744073709551615")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.draw(nil)
+	if r.Collisions() != 0 {
+		t.Errorf("Collisions() = %d after one draw", r.Collisions())
+	}
+}
+
+// The same source draws the same Ids, and only the last IDS_REMEMBERED to twice as many are kept
+func TestIdRangeSource(t *testing.T) {
+	a, _ := ParseIdRange("0-18446744073709551615")
+	b, _ := ParseIdRange("0-18446744073709551615")
+	srcA, srcB := rand.NewSource(1), rand.NewSource(1)
+	for i := 0; i < 2*IDS_REMEMBERED+1; i++ {
+		if idA, idB := a.draw(srcA), b.draw(srcB); idA != idB {
+			t.Fatalf("draw %d: %d and %d from sources of the same seed", i, idA, idB)
+		}
+	}
+	if n := len(a.used) + len(a.previous); n < IDS_REMEMBERED || n > 2*IDS_REMEMBERED {
+		t.Errorf("%d Ids remembered, want %d to %d", n, IDS_REMEMBERED, 2*IDS_REMEMBERED)
+	}
+	// 2^64 mod 3 is 1, so of a width of 3 the value 0 is rejected and drawn again
+	r, _ := ParseIdRange("10-12")
+	src := rand.NewSource(2)
+	for i := 0; i < 100; i++ {
+		if id := r.draw(src); id < 10 || id > 12 {
+			t.Fatalf("drew Id %d outside of 10-12", id)
+		}
+	}
+}
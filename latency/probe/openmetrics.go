@@ -0,0 +1,110 @@
+package probe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric of WriteOpenMetrics, in base units as OpenMetrics requires
+type metricDef struct {
+	name  string
+	kind  string
+	unit  string
+	help  string
+	value func(result *Result) float64
+}
+
+var METRICS = []metricDef{
+	{"scion_rtt_avg_seconds", "gauge", "seconds", "Average round-trip time",
+		func(r *Result) float64 { return r.RTTAvg / 1e3 }},
+	{"scion_rtt_min_seconds", "gauge", "seconds", "Minimum round-trip time",
+		func(r *Result) float64 { return r.RTTMin / 1e3 }},
+	{"scion_rtt_max_seconds", "gauge", "seconds", "Maximum round-trip time",
+		func(r *Result) float64 { return r.RTTMax / 1e3 }},
+	{"scion_rtt_range_seconds", "gauge", "seconds", "Maximum minus minimum round-trip time",
+		func(r *Result) float64 { return r.Range / 1e3 }},
+	{"scion_rtt_samples", "gauge", "", "Number of replies received",
+		func(r *Result) float64 { return float64(r.Samples) }},
+	{"scion_probe_bytes", "counter", "bytes", "Bytes sent by the probes",
+		func(r *Result) float64 { return float64(r.BytesSent) }},
+}
+
+// Upper bounds in seconds of the RTT histogram of WriteOpenMetrics
+var RTT_BUCKETS = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Escapes a label value as required by the OpenMetrics text format
+func escapeLabel(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+	return strings.Replace(value, "\n", "\\n", -1)
+}
+
+// Writes the result to w in the OpenMetrics text format, timestamped with now. If samples are
+// given, they are also written as an RTT histogram, with the slowest probe of each bucket as its
+// exemplar. The exposition is written at once, so w never gets one without its # EOF.
+func WriteOpenMetrics(w io.Writer, result *Result, now time.Time, samples []Sample) error {
+	var out bytes.Buffer
+	labelSet := fmt.Sprintf("src=\"%s\",dst=\"%s\",mode=\"%s\"",
+		escapeLabel(result.Source), escapeLabel(result.Destination), escapeLabel(result.Mode))
+	labels := "{" + labelSet + "}"
+	timestamp := strconv.FormatFloat(float64(now.UnixNano())/1e9, 'f', 3, 64)
+	for _, m := range METRICS {
+		fmt.Fprintf(&out, "# TYPE %s %s\n", m.name, m.kind)
+		if len(m.unit) > 0 {
+			fmt.Fprintf(&out, "# UNIT %s %s\n", m.name, m.unit)
+		}
+		fmt.Fprintf(&out, "# HELP %s %s\n", m.name, m.help)
+		sample := m.name
+		if m.kind == "counter" {
+			sample += "_total"
+		}
+		fmt.Fprintf(&out, "%s%s %s %s\n", sample, labels, strconv.FormatFloat(m.value(result), 'g', -1, 64),
+			timestamp)
+	}
+	if len(samples) > 0 {
+		writeRTTHistogram(&out, labelSet, timestamp, samples)
+	}
+	out.WriteString("# EOF\n")
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func writeRTTHistogram(out *bytes.Buffer, labelSet string, timestamp string, samples []Sample) {
+	counts := make([]int, len(RTT_BUCKETS)+1)
+	exemplars := make([]*Sample, len(RTT_BUCKETS)+1)
+	var sum float64
+	for i := range samples {
+		rtt := float64(samples[i].RTT) / 1e9
+		sum += rtt
+		bucket := sort.SearchFloat64s(RTT_BUCKETS, rtt)
+		counts[bucket] += 1
+		if exemplars[bucket] == nil || samples[i].RTT > exemplars[bucket].RTT {
+			exemplars[bucket] = &samples[i]
+		}
+	}
+
+	out.WriteString("# TYPE scion_rtt_seconds histogram\n")
+	out.WriteString("# UNIT scion_rtt_seconds seconds\n")
+	out.WriteString("# HELP scion_rtt_seconds Round-trip times of the probes\n")
+	cumulative := 0
+	for i := range counts {
+		cumulative += counts[i]
+		le := "+Inf"
+		if i < len(RTT_BUCKETS) {
+			le = strconv.FormatFloat(RTT_BUCKETS[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(out, "scion_rtt_seconds_bucket{%s,le=\"%s\"} %d %s", labelSet, le, cumulative, timestamp)
+		if exemplars[i] != nil {
+			fmt.Fprintf(out, " # {seq=\"%d\"} %s", exemplars[i].Seq,
+				strconv.FormatFloat(float64(exemplars[i].RTT)/1e9, 'g', -1, 64))
+		}
+		out.WriteString("\n")
+	}
+	fmt.Fprintf(out, "scion_rtt_seconds_count{%s} %d %s\n", labelSet, len(samples), timestamp)
+	fmt.Fprintf(out, "scion_rtt_seconds_sum{%s} %s %s\n", labelSet, strconv.FormatFloat(sum, 'g', -1, 64), timestamp)
+}
@@ -0,0 +1,151 @@
+package probe
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The parts of the OpenMetrics text format (ABNF in the specification) that WriteOpenMetrics uses
+const (
+	omName   = `[a-zA-Z_:][a-zA-Z0-9_:]*`
+	omLabel  = `[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"`
+	omLabels = `\{(?:` + omLabel + `(?:,` + omLabel + `)*)?\}`
+	omNumber = `[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][+-]?[0-9]+)?|[+-]?Inf|NaN`
+)
+
+var (
+	omType = regexp.MustCompile(`^# TYPE (` + omName + `) ` +
+		`(counter|gauge|histogram|gaugehistogram|stateset|info|summary|unknown)$`)
+	omUnit   = regexp.MustCompile(`^# UNIT (` + omName + `) ([a-zA-Z0-9_:]*)$`)
+	omHelp   = regexp.MustCompile(`^# HELP (` + omName + `) (?:[^\\\n]|\\[\\"n])*$`)
+	omSample = regexp.MustCompile(`^(` + omName + `)(` + omLabels + `)? (` + omNumber + `)(?: (` + omNumber + `))?` +
+		`(?: # (` + omLabels + `) (` + omNumber + `)(?: (` + omNumber + `))?)?$`)
+	omLeLabel = regexp.MustCompile(`[{,]le="([^"]*)"`)
+	// Suffixes of the samples of each metric type, exemplars are only allowed on the marked ones
+	omSuffixes = map[string]map[string]bool{
+		"counter":   {"_total": true, "_created": false},
+		"gauge":     {"": false},
+		"histogram": {"_bucket": true, "_count": false, "_sum": false, "_created": false},
+	}
+)
+
+// Checks text against the OpenMetrics grammar and the rules on metric families that matter here:
+// metadata before the samples of a family, no family twice, a unit that ends the name, sample
+// names by type, cumulative histogram buckets ending in +Inf that agree with _count
+func validateOpenMetrics(text string) error {
+	if !strings.HasSuffix(text, "# EOF\n") {
+		return fmt.Errorf("does not end with # EOF")
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "# EOF\n"), "\n")
+	lines = lines[:len(lines)-1]
+	seen := make(map[string]bool)
+	var family, kind string
+	var sampled bool
+	var lastLe float64
+	var lastBucket, infBucket int64
+	for i, line := range lines {
+		n := i + 1
+		if m := omType.FindStringSubmatch(line); m != nil {
+			if seen[m[1]] {
+				return fmt.Errorf("line %d: family %s appears twice", n, m[1])
+			}
+			seen[m[1]] = true
+			family, kind, sampled = m[1], m[2], false
+			lastLe, lastBucket, infBucket = -1, 0, -1
+			continue
+		}
+		if m := omUnit.FindStringSubmatch(line); m != nil {
+			if m[1] != family || sampled {
+				return fmt.Errorf("line %d: UNIT of %s after the samples or outside its family", n, m[1])
+			}
+			if !strings.HasSuffix(family, "_"+m[2]) {
+				return fmt.Errorf("line %d: family %s does not end with its unit %s", n, family, m[2])
+			}
+			continue
+		}
+		if m := omHelp.FindStringSubmatch(line); m != nil {
+			if m[1] != family || sampled {
+				return fmt.Errorf("line %d: HELP of %s after the samples or outside its family", n, m[1])
+			}
+			continue
+		}
+		m := omSample.FindStringSubmatch(line)
+		if m == nil {
+			return fmt.Errorf("line %d: not a valid line: %q", n, line)
+		}
+		if !strings.HasPrefix(m[1], family) {
+			return fmt.Errorf("line %d: sample %s outside of its family %s", n, m[1], family)
+		}
+		exemplar, ok := omSuffixes[kind][m[1][len(family):]]
+		if !ok {
+			return fmt.Errorf("line %d: invalid sample %s of %s %s", n, m[1], kind, family)
+		}
+		if len(m[5]) > 0 && !exemplar {
+			return fmt.Errorf("line %d: exemplar on sample %s", n, m[1])
+		}
+		sampled = true
+		if kind != "histogram" {
+			continue
+		}
+		value, err := strconv.ParseInt(m[3], 10, 64)
+		if m[1] == family+"_bucket" {
+			le := omLeLabel.FindStringSubmatch(m[2])
+			if le == nil || err != nil {
+				return fmt.Errorf("line %d: bucket without le or with a non-integer count", n)
+			}
+			bound, _ := strconv.ParseFloat(le[1], 64)
+			if bound <= lastLe || value < lastBucket || infBucket >= 0 {
+				return fmt.Errorf("line %d: buckets out of order or not cumulative", n)
+			}
+			lastLe, lastBucket = bound, value
+			if le[1] == "+Inf" {
+				infBucket = value
+			}
+		} else if m[1] == family+"_count" && (infBucket < 0 || value != infBucket) {
+			return fmt.Errorf("line %d: _count %s does not match the +Inf bucket %d", n, m[3], infBucket)
+		}
+	}
+	return nil
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	result := &Result{Source: `1-ff00:0:1,[10.0.0.1]`, Destination: "1-ff00:0:2,[\"odd\\\nhost\"]", Mode: MODE_SCMP,
+		RTTAvg: 12.5, RTTMin: 10, RTTMax: 20, Range: 10, Samples: 4, BytesSent: 448}
+	samples := []Sample{{Seq: 1, RTT: 10e6}, {Seq: 2, RTT: 12e6}, {Seq: 3, RTT: 20e6}, {Seq: 5, RTT: 3e9}}
+	now := time.Unix(1700000000, 123e6)
+	for _, histogram := range [][]Sample{nil, samples} {
+		var out bytes.Buffer
+		if err := WriteOpenMetrics(&out, result, now, histogram); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateOpenMetrics(out.String()); err != nil {
+			t.Errorf("invalid OpenMetrics with %d samples: %v\n%s", len(histogram), err, out.String())
+		}
+		if got := strings.Contains(out.String(), "scion_rtt_seconds_bucket"); got != (histogram != nil) {
+			t.Errorf("histogram written = %v with %d samples", got, len(histogram))
+		}
+	}
+}
+
+func TestValidateOpenMetricsRejects(t *testing.T) {
+	for _, text := range []string{
+		"# TYPE a gauge\na 1\n",
+		"# TYPE a gauge\na 1\n# EOF\na 2\n# EOF\n",
+		"# TYPE a gauge\na{b=\"\n\"} 1\n# EOF\n",
+		"# TYPE a_seconds gauge\n# UNIT a_seconds bytes\na_seconds 1\n# EOF\n",
+		"# TYPE a counter\na 1\n# EOF\n",
+		"# TYPE a gauge\na 1\n# TYPE a gauge\n# EOF\n",
+		"# TYPE a gauge\na 1 # {seq=\"1\"} 1\n# EOF\n",
+		"# TYPE h histogram\nh_bucket{le=\"1\"} 2\nh_bucket{le=\"+Inf\"} 1\nh_count 1\n# EOF\n",
+		"# TYPE h histogram\nh_bucket{le=\"+Inf\"} 2\nh_count 1\n# EOF\n",
+	} {
+		if validateOpenMetrics(text) == nil {
+			t.Errorf("validateOpenMetrics accepted %q", text)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package probe
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/common"
+	"github.com/scionproto/scion/go/lib/hpkt"
+	"github.com/scionproto/scion/go/lib/layers"
+	"github.com/scionproto/scion/go/lib/scmp"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/spath"
+	"github.com/scionproto/scion/go/lib/spkt"
+)
+
+const (
+	// Largest SCMP echo payload, the padding is carried as a quoted L4 header of at most 255 lines
+	MAX_SCMP_SIZE = scmp.MetaLen + 16 + 255*common.LineLen
+
+	// Room for the headers around the path and payload of a probe: common header, addresses,
+	// the SCMP extension, SCMP header, meta and info
+	PROBE_HEADROOM = 256
+)
+
+// Hop-by-hop extensions that Config.Ext attaches to echo requests, by name
+var EXTENSIONS = map[string]func() common.Extension{
+	// Marks the packet as SCMP, so every router on the path processes the hop-by-hop extension
+	"scmp": func() common.Extension { return &layers.ExtnSCMP{Error: false, HopByHop: true} },
+}
+
+// Echo request with the given Id and sequence number whose payload is padded to at least size
+// bytes, in whole lines
+func createScmpEchoReqPkt(local *snet.Addr, remote *snet.Addr, size int, id uint64, seq uint16,
+	extensions []common.Extension) *spkt.ScnPkt {
+	info := &scmp.InfoEcho{Id: id, Seq: seq}
+
+	padLen := 0
+	if size > scmp.MetaLen+info.Len() {
+		padLen = (size - scmp.MetaLen - info.Len() + common.LineLen - 1) / common.LineLen * common.LineLen
+	}
+	scmpMeta := scmp.Meta{
+		InfoLen:  uint8(info.Len() / common.LineLen),
+		L4HdrLen: uint8(padLen / common.LineLen),
+	}
+	pld := make(common.RawBytes, scmp.MetaLen+info.Len()+padLen)
+	scmpMeta.Write(pld)
+	info.Write(pld[scmp.MetaLen:])
+	scmpHdr := scmp.NewHdr(scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoRequest}, len(pld))
+
+	pkt := &spkt.ScnPkt{
+		DstIA:   remote.IA,
+		SrcIA:   local.IA,
+		DstHost: remote.Host,
+		SrcHost: local.Host,
+		Path:    remote.Path,
+		HBHExt:  extensions,
+		L4:      scmpHdr,
+		Pld:     pld,
+	}
+
+	return pkt
+}
+
+// Traceroute request answered by the border router owning the hop field at hopOff, instead of by the host
+func createScmpTraceRouteReqPkt(local *snet.Addr, remote *snet.Addr, hopOff uint8) (uint64, *spkt.ScnPkt) {
+	id := randomUint64()
+	info := &scmp.InfoTraceRoute{Id: id, HopOff: hopOff}
+
+	scmpMeta := scmp.Meta{InfoLen: uint8(info.Len() / common.LineLen)}
+	pld := make(common.RawBytes, scmp.MetaLen+info.Len())
+	scmpMeta.Write(pld)
+	info.Write(pld[scmp.MetaLen:])
+	scmpHdr := scmp.NewHdr(scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_TraceRouteRequest}, len(pld))
+
+	pkt := &spkt.ScnPkt{
+		DstIA:   remote.IA,
+		SrcIA:   local.IA,
+		DstHost: remote.Host,
+		SrcHost: local.Host,
+		Path:    remote.Path,
+		// Routers only inspect SCMP packets carrying the hop-by-hop SCMP extension
+		HBHExt: []common.Extension{&layers.ExtnSCMP{Error: false, HopByHop: true}},
+		L4:     scmpHdr,
+		Pld:    pld,
+	}
+
+	return id, pkt
+}
+
+// Offsets (in lines) of the routing hop fields of a path, in path order
+func hopFieldOffsets(path *spath.Path) ([]uint8, error) {
+	var offsets []uint8
+	offset := 0
+	for offset < len(path.Raw) {
+		info, err := spath.InfoFFromRaw(path.Raw[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += spath.InfoFieldLength
+		for i := 0; i < int(info.Hops); i++ {
+			hop, err := spath.HopFFromRaw(path.Raw[offset:])
+			if err != nil {
+				return nil, err
+			}
+			if !hop.VerifyOnly {
+				offsets = append(offsets, uint8(offset/common.LineLen))
+			}
+			offset += spath.HopFieldLength
+		}
+	}
+	return offsets, nil
+}
+
+// Serializes pkt into b. A length beyond b would mean that the packet was cut short, sending
+// b[:pktLen] would then send a malformed probe or read past the buffer, so it is an error.
+func serializeProbe(pkt *spkt.ScnPkt, b common.RawBytes) (int, error) {
+	pktLen, err := hpkt.WriteScnPkt(pkt, b)
+	if err != nil {
+		return 0, fmt.Errorf("Error, cannot serialize probe: %v", err)
+	}
+	if pktLen > len(b) {
+		return 0, fmt.Errorf("Error, probe of %d bytes exceeds the send buffer of %d bytes", pktLen, len(b))
+	}
+	return pktLen, nil
+}
+
+// Checks that a whole packet was read, according to the total length in its common header
+func checkTruncated(b common.RawBytes) error {
+	if len(b) < spkt.CmnHdrLen {
+		return &MeasurementError{Kind: ErrTruncated,
+			Err: common.NewBasicError("Truncated reply", nil, "received", len(b), "expected", spkt.CmnHdrLen)}
+	}
+	cmnHdr, err := spkt.CmnHdrFromRaw(b)
+	if err != nil {
+		return err
+	}
+	if len(b) < int(cmnHdr.TotalLen) {
+		return &MeasurementError{Kind: ErrTruncated,
+			Err: common.NewBasicError("Truncated reply", nil, "received", len(b), "expected", cmnHdr.TotalLen)}
+	}
+	return nil
+}
+
+func validatePkt(pkt *spkt.ScnPkt, id uint64) (*scmp.Hdr, *scmp.InfoEcho, error) {
+	scmpHdr, ok := pkt.L4.(*scmp.Hdr)
+	if !ok {
+		return nil, nil,
+			common.NewBasicError("Not an SCMP header", nil, "type", common.TypeOf(pkt.L4))
+	}
+	scmpPld, ok := pkt.Pld.(*scmp.Payload)
+	if !ok {
+		return nil, nil,
+			common.NewBasicError("Not an SCMP payload", nil, "type", common.TypeOf(pkt.Pld))
+	}
+	info, ok := scmpPld.Info.(*scmp.InfoEcho)
+	if !ok {
+		return nil, nil,
+			common.NewBasicError("Not an Info Echo", nil, "type", common.TypeOf(info))
+	}
+	return scmpHdr, info, nil
+}
+
+// Checks that a reply came from the probed destination IA and, if checkHost, from its host
+func validateSource(srcIA addr.IA, srcHost addr.HostAddr, remote *snet.Addr, checkHost bool) error {
+	if !srcIA.Eq(remote.IA) {
+		return fmt.Errorf("reply from IA %s, expected %s", srcIA, remote.IA)
+	}
+	// A service address is answered by one of its instances, from the instance's own address
+	if _, ok := remote.Host.(addr.HostSVC); ok {
+		return nil
+	}
+	if checkHost && (srcHost == nil || !srcHost.Eq(remote.Host)) {
+		return fmt.Errorf("reply from host %v, expected %s", srcHost, remote.Host)
+	}
+	return nil
+}
+
+func validateTraceRoutePkt(pkt *spkt.ScnPkt) (*scmp.Hdr, *scmp.InfoTraceRoute, error) {
+	scmpHdr, ok := pkt.L4.(*scmp.Hdr)
+	if !ok {
+		return nil, nil,
+			common.NewBasicError("Not an SCMP header", nil, "type", common.TypeOf(pkt.L4))
+	}
+	scmpPld, ok := pkt.Pld.(*scmp.Payload)
+	if !ok {
+		return nil, nil,
+			common.NewBasicError("Not an SCMP payload", nil, "type", common.TypeOf(pkt.Pld))
+	}
+	info, ok := scmpPld.Info.(*scmp.InfoTraceRoute)
+	if !ok {
+		return nil, nil,
+			common.NewBasicError("Not an Info TraceRoute", nil, "type", common.TypeOf(scmpPld.Info))
+	}
+	return scmpHdr, info, nil
+}
+
+// Request that the SCMP error pkt quotes: the SCMP header of the offending packet and, if the
+// quote goes on past it, the Info that follows its Meta. info is empty for a quoted header alone.
+func quotedRequest(pkt *spkt.ScnPkt) (*scmp.Hdr, common.RawBytes, error) {
+	scmpPld, ok := pkt.Pld.(*scmp.Payload)
+	if !ok {
+		return nil, nil,
+			common.NewBasicError("Not an SCMP payload", nil, "type", common.TypeOf(pkt.Pld))
+	}
+	quote := scmpPld.L4Hdr
+	if len(quote) < scmp.HdrLen {
+		return nil, nil, common.NewBasicError("Quote without an SCMP header", nil, "len", len(quote))
+	}
+	hdr, err := scmp.HdrFromRaw(quote)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(quote) < scmp.HdrLen+scmp.MetaLen {
+		return hdr, nil, nil
+	}
+	return hdr, quote[scmp.HdrLen+scmp.MetaLen:], nil
+}
+
+// Fingerprint of a reply path, the FNV-1a hash of its raw bytes, "direct" for replies from within
+// the local AS which carry none
+func PathFingerprint(path *spath.Path) string {
+	if path == nil || len(path.Raw) == 0 {
+		return "direct"
+	}
+	h := fnv.New64a()
+	h.Write(path.Raw)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,105 @@
+package probe
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/common"
+	"github.com/scionproto/scion/go/lib/hpkt"
+	"github.com/scionproto/scion/go/lib/scmp"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/spkt"
+)
+
+// Local and remote address of the probes of the tests, without a path
+func testAddrs() (*snet.Addr, *snet.Addr) {
+	return &snet.Addr{IA: addr.IA{I: 1, A: 0xff0000000001}, Host: addr.HostFromIP(net.IPv4(10, 0, 0, 1))},
+		&snet.Addr{IA: addr.IA{I: 1, A: 0xff0000000002}, Host: addr.HostFromIP(net.IPv4(10, 0, 0, 2))}
+}
+
+func TestCheckTruncated(t *testing.T) {
+	const totalLen = 96
+	b := make(common.RawBytes, totalLen+8)
+	(&spkt.CmnHdr{TotalLen: totalLen, HdrLen: 4}).Write(b)
+	for _, n := range []int{totalLen, totalLen + 8} {
+		if err := checkTruncated(b[:n]); err != nil {
+			t.Errorf("checkTruncated rejected %d bytes of a %d byte packet: %v", n, totalLen, err)
+		}
+	}
+	// A short read cut off within the common header, or after it, before the end of the packet
+	for _, n := range []int{0, spkt.CmnHdrLen - 1, spkt.CmnHdrLen, totalLen / 2, totalLen - 1} {
+		if err := checkTruncated(b[:n]); !errors.Is(err, ErrTruncated) {
+			t.Errorf("checkTruncated(%d of %d bytes) = %v, want ErrTruncated", n, totalLen, err)
+		}
+	}
+}
+
+// Building an echo request, the payload is allocated anew for every probe
+func BenchmarkCreateScmpEchoReqPkt(b *testing.B) {
+	local, remote := testAddrs()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		createScmpEchoReqPkt(local, remote, 64, uint64(i), uint16(i), nil)
+	}
+}
+
+// A probe as the session sends and receives it: built, serialized into a reused buffer, checked,
+// parsed and validated
+func BenchmarkWriteParseRoundTrip(b *testing.B) {
+	local, remote := testAddrs()
+	buf := make(common.RawBytes, common.MinMTU)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pktLen, err := serializeProbe(createScmpEchoReqPkt(local, remote, 64, uint64(i), uint16(i), nil), buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := checkTruncated(buf[:pktLen]); err != nil {
+			b.Fatal(err)
+		}
+		pkt := &spkt.ScnPkt{}
+		if err := hpkt.ParseScnPkt(pkt, buf[:pktLen]); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := validatePkt(pkt, uint64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// SCMP error about an echo request, quoting its SCMP header and as much of its Meta and Info
+// as quoteLen allows
+func scmpErrorQuoting(id uint64, seq uint16, timestamp uint64, quoteLen int) *spkt.ScnPkt {
+	quote := make(common.RawBytes, scmp.HdrLen+scmp.MetaLen+(&scmp.InfoEcho{}).Len())
+	(&scmp.Hdr{Class: scmp.C_General, Type: scmp.T_G_EchoRequest, Timestamp: timestamp}).Write(quote)
+	(&scmp.InfoEcho{Id: id, Seq: seq}).Write(quote[scmp.HdrLen+scmp.MetaLen:])
+	local, remote := testAddrs()
+	return &spkt.ScnPkt{SrcIA: remote.IA, DstIA: local.IA, L4: &scmp.Hdr{Class: scmp.C_Path, Type: 1},
+		Pld: &scmp.Payload{L4Hdr: quote[:quoteLen]}}
+}
+
+func TestMatchSCMPError(t *testing.T) {
+	local, remote := testAddrs()
+	s := &session{local: local, remote: remote, seq: 7, sentStamp: 1000}
+	full := scmp.HdrLen + scmp.MetaLen + (&scmp.InfoEcho{}).Len()
+	for _, c := range []struct {
+		pkt  *spkt.ScnPkt
+		ours bool
+	}{
+		{scmpErrorQuoting(42, 7, 1000, full), true},
+		{scmpErrorQuoting(42, 6, 1000, full), false},
+		{scmpErrorQuoting(43, 7, 1000, full), false},
+		// Without the Info, the timestamp of the quoted header tells the probes apart
+		{scmpErrorQuoting(43, 6, 1000, scmp.HdrLen), true},
+		{scmpErrorQuoting(42, 7, 999, scmp.HdrLen), false},
+		{scmpErrorQuoting(42, 7, 1000, scmp.HdrLen-1), false},
+	} {
+		err := s.matchSCMP(c.pkt, 42)
+		if err == nil || errors.Is(err, ErrSCMPError) != c.ours {
+			t.Errorf("matchSCMP(error quoting %d bytes) = %v, want ErrSCMPError %v",
+				len(c.pkt.Pld.(*scmp.Payload).L4Hdr), err, c.ours)
+		}
+	}
+}
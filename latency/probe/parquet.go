@@ -0,0 +1,302 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+// Thrift compact protocol types, as used by the Parquet metadata
+const (
+	THRIFT_I32 = 5
+	THRIFT_I64 = 6
+	THRIFT_BINARY = 8
+	THRIFT_LIST = 9
+	THRIFT_STRUCT = 12
+)
+
+// Encodes structs in the Thrift compact protocol, just the field types the Parquet page headers
+// and footer need. Field ids are delta encoded against the last one of the enclosing struct.
+type thriftWriter struct {
+	bytes.Buffer
+	last []int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// Zigzag encoded, so small negative numbers stay short
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	top := len(t.last) - 1
+	if delta := id - t.last[top]; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.last[top] = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, THRIFT_I32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, THRIFT_I64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, THRIFT_BINARY)
+	t.uvarint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+// Header of a list of n elements of elemType, the elements follow without field headers
+func (t *thriftWriter) list(id int16, elemType byte, n int) {
+	t.field(id, THRIFT_LIST)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		t.WriteByte(0xf0 | elemType)
+		t.uvarint(uint64(n))
+	}
+}
+
+// Starts a struct as field id, or with id 0 as the top level struct or a list element
+func (t *thriftWriter) begin(id int16) {
+	if id > 0 {
+		t.field(id, THRIFT_STRUCT)
+	}
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// Parquet physical types, repetition, encodings and page type of the -parquet file
+const (
+	PARQUET_INT64 = 2
+	PARQUET_BYTE_ARRAY = 6
+	PARQUET_REQUIRED = 0
+	PARQUET_UTF8 = 0
+	PARQUET_PLAIN = 0
+	PARQUET_RLE = 3
+	PARQUET_DATA_PAGE = 0
+	// Rows are buffered and written as a row group this many at a time
+	PARQUET_ROW_GROUP = 65536
+	PARQUET_SUMMARY_KEY = "scion_latency.summary"
+)
+
+// Columns of the -parquet file. The times are ns since the epoch, path_id is the fingerprint of
+// the path the reply took (see PathFingerprint).
+var PARQUET_COLUMNS = []struct {
+	name string
+	typ  int32
+}{
+	{"seq", PARQUET_INT64},
+	{"send_ts", PARQUET_INT64},
+	{"recv_ts", PARQUET_INT64},
+	{"rtt_ns", PARQUET_INT64},
+	{"path_id", PARQUET_BYTE_ARRAY},
+}
+
+// Row of a reply, see PARQUET_COLUMNS
+type ParquetRow struct {
+	Seq      int64
+	Sent     int64
+	Received int64
+	RTT      int64
+	Path     string
+}
+
+// Offset and size, page header included, of a column chunk in the file
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// Writes a row for every reply to a Parquet file: uncompressed, PLAIN encoded, one data page per
+// column of a row group. The footer is written on Close, with the run's JSON summary as the
+// key-value metadata PARQUET_SUMMARY_KEY, so a file of an interrupted run has no summary.
+type ParquetWriter struct {
+	file      *os.File
+	out       *bufio.Writer
+	offset    int64
+	rows      []ParquetRow
+	rowGroups []parquetRowGroup
+	closed    bool
+	createdBy string
+	err       error // First write error, every later write is skipped and returns it
+}
+
+// Creates the Parquet file at path and writes its magic, createdBy names the writer in the footer
+func OpenParquetWriter(path string, createdBy string) (*ParquetWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &ParquetWriter{file: file, out: bufio.NewWriter(file), createdBy: createdBy}
+	w.emit([]byte("PAR1"))
+	return w, nil
+}
+
+func (w *ParquetWriter) emit(b []byte) {
+	if w.err == nil {
+		_, w.err = w.out.Write(b)
+	}
+	w.offset += int64(len(b))
+}
+
+func (w *ParquetWriter) Write(row ParquetRow) error {
+	w.rows = append(w.rows, row)
+	if len(w.rows) >= PARQUET_ROW_GROUP {
+		w.flush()
+	}
+	return w.err
+}
+
+// Writes the buffered rows as a row group
+func (w *ParquetWriter) flush() {
+	if len(w.rows) == 0 {
+		return
+	}
+	group := parquetRowGroup{rows: int64(len(w.rows))}
+	for i := range PARQUET_COLUMNS {
+		page := new(bytes.Buffer)
+		for _, row := range w.rows {
+			switch i {
+			case 0:
+				binary.Write(page, binary.LittleEndian, row.Seq)
+			case 1:
+				binary.Write(page, binary.LittleEndian, row.Sent)
+			case 2:
+				binary.Write(page, binary.LittleEndian, row.Received)
+			case 3:
+				binary.Write(page, binary.LittleEndian, row.RTT)
+			case 4:
+				binary.Write(page, binary.LittleEndian, uint32(len(row.Path)))
+				page.WriteString(row.Path)
+			}
+		}
+		// The columns are required and flat, so the page holds no repetition or definition levels
+		header := &thriftWriter{}
+		header.begin(0)
+		header.i32(1, PARQUET_DATA_PAGE)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.begin(5)
+		header.i32(1, int32(len(w.rows)))
+		header.i32(2, PARQUET_PLAIN)
+		header.i32(3, PARQUET_RLE)
+		header.i32(4, PARQUET_RLE)
+		header.end()
+		header.end()
+
+		chunk := parquetChunk{offset: w.offset, size: int64(header.Len() + page.Len())}
+		w.emit(header.Bytes())
+		w.emit(page.Bytes())
+		group.chunks = append(group.chunks, chunk)
+	}
+	w.rowGroups = append(w.rowGroups, group)
+	w.rows = w.rows[:0]
+}
+
+// Writes the remaining rows and the footer, summary is the JSON of the result or empty
+func (w *ParquetWriter) Close(summary string) error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.flush()
+
+	var numRows int64
+	for _, group := range w.rowGroups {
+		numRows += group.rows
+	}
+	footer := &thriftWriter{}
+	footer.begin(0)
+	footer.i32(1, 1)
+	footer.list(2, THRIFT_STRUCT, 1+len(PARQUET_COLUMNS))
+	footer.begin(0)
+	footer.str(4, "schema")
+	footer.i32(5, int32(len(PARQUET_COLUMNS)))
+	footer.end()
+	for _, column := range PARQUET_COLUMNS {
+		footer.begin(0)
+		footer.i32(1, column.typ)
+		footer.i32(3, PARQUET_REQUIRED)
+		footer.str(4, column.name)
+		if column.typ == PARQUET_BYTE_ARRAY {
+			footer.i32(6, PARQUET_UTF8)
+		}
+		footer.end()
+	}
+	footer.i64(3, numRows)
+	footer.list(4, THRIFT_STRUCT, len(w.rowGroups))
+	for _, group := range w.rowGroups {
+		var groupSize int64
+		footer.begin(0)
+		footer.list(1, THRIFT_STRUCT, len(group.chunks))
+		for i, chunk := range group.chunks {
+			footer.begin(0)
+			footer.i64(2, chunk.offset)
+			footer.begin(3)
+			footer.i32(1, PARQUET_COLUMNS[i].typ)
+			footer.list(2, THRIFT_I32, 1)
+			footer.varint(PARQUET_PLAIN)
+			footer.list(3, THRIFT_BINARY, 1)
+			footer.uvarint(uint64(len(PARQUET_COLUMNS[i].name)))
+			footer.WriteString(PARQUET_COLUMNS[i].name)
+			footer.i32(4, 0) // Uncompressed
+			footer.i64(5, group.rows)
+			footer.i64(6, chunk.size)
+			footer.i64(7, chunk.size)
+			footer.i64(9, chunk.offset)
+			footer.end()
+			footer.end()
+			groupSize += chunk.size
+		}
+		footer.i64(2, groupSize)
+		footer.i64(3, group.rows)
+		footer.end()
+	}
+	if len(summary) > 0 {
+		footer.list(5, THRIFT_STRUCT, 1)
+		footer.begin(0)
+		footer.str(1, PARQUET_SUMMARY_KEY)
+		footer.str(2, summary)
+		footer.end()
+	}
+	footer.str(6, w.createdBy)
+	footer.end()
+
+	w.emit(footer.Bytes())
+	var footerLen [4]byte
+	binary.LittleEndian.PutUint32(footerLen[:], uint32(footer.Len()))
+	w.emit(footerLen[:])
+	w.emit([]byte("PAR1"))
+	err := w.err
+	if flushErr := w.out.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
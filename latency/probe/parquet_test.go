@@ -0,0 +1,181 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Decodes a struct of the Thrift compact protocol into its fields by id: integers as int64,
+// binaries as string, lists as []interface{} and structs as map[int16]interface{}
+func readThriftStruct(r *bytes.Reader) (map[int16]interface{}, error) {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return fields, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			long, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, err
+			}
+			id = int16(long)
+		}
+		if fields[id], err = readThriftValue(r, header&0x0f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readThriftValue(r *bytes.Reader, typ byte) (interface{}, error) {
+	switch typ {
+	case THRIFT_I32, THRIFT_I64:
+		return binary.ReadVarint(r)
+	case THRIFT_BINARY:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b), err
+	case THRIFT_LIST:
+		header, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(header >> 4)
+		if n == 15 {
+			if n, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = readThriftValue(r, header&0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case THRIFT_STRUCT:
+		return readThriftStruct(r)
+	}
+	return nil, fmt.Errorf("unexpected Thrift type %d", typ)
+}
+
+func TestParquetWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "probes.parquet")
+
+	rows := []ParquetRow{
+		{Seq: 1, Sent: 1000, Received: 3500, RTT: 2500, Path: "a1b2c3d4"},
+		{Seq: 2, Sent: 2000, Received: 4100, RTT: 2100, Path: "a1b2c3d4"},
+		{Seq: 4, Sent: 4000, Received: 7000, RTT: 3000, Path: "e5f6a7b8"},
+	}
+	w, err := OpenParquetWriter(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(`{"samples":3}`); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("file of %d bytes does not start and end with PAR1", len(data))
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen <= 0 || footerLen > len(data)-12 {
+		t.Fatalf("footer length %d does not fit a file of %d bytes", footerLen, len(data))
+	}
+	footer := bytes.NewReader(data[len(data)-8-footerLen : len(data)-8])
+	meta, err := readThriftStruct(footer)
+	if err != nil {
+		t.Fatalf("cannot decode FileMetaData: %v", err)
+	}
+	if footer.Len() != 0 {
+		t.Errorf("%d bytes of the footer follow FileMetaData", footer.Len())
+	}
+
+	if meta[1] != int64(1) || meta[3] != int64(len(rows)) || meta[6] != "test" {
+		t.Errorf("version %v, num_rows %v, created_by %v", meta[1], meta[3], meta[6])
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != 1+len(PARQUET_COLUMNS) {
+		t.Fatalf("schema has %d elements, want the root and %d columns", len(schema), len(PARQUET_COLUMNS))
+	}
+	root := schema[0].(map[int16]interface{})
+	if root[4] != "schema" || root[5] != int64(len(PARQUET_COLUMNS)) {
+		t.Errorf("schema root %v", root)
+	}
+	want := []struct {
+		name string
+		typ  int64
+	}{{"seq", PARQUET_INT64}, {"send_ts", PARQUET_INT64}, {"recv_ts", PARQUET_INT64},
+		{"rtt_ns", PARQUET_INT64}, {"path_id", PARQUET_BYTE_ARRAY}}
+	for i, column := range want {
+		element := schema[1+i].(map[int16]interface{})
+		if element[4] != column.name || element[1] != column.typ || element[3] != int64(PARQUET_REQUIRED) {
+			t.Errorf("schema column %d = %v, want required %s of type %d", i, element, column.name, column.typ)
+		}
+		if _, utf8 := element[6]; utf8 != (column.typ == PARQUET_BYTE_ARRAY) {
+			t.Errorf("column %s has converted type %v", column.name, element[6])
+		}
+	}
+	keyValues := meta[5].([]interface{})
+	summary := keyValues[0].(map[int16]interface{})
+	if len(keyValues) != 1 || summary[1] != PARQUET_SUMMARY_KEY || summary[2] != `{"samples":3}` {
+		t.Errorf("key-value metadata %v", keyValues)
+	}
+
+	// The seq column chunk holds the page header and the PLAIN encoded values
+	groups := meta[4].([]interface{})
+	group := groups[0].(map[int16]interface{})
+	if len(groups) != 1 || group[3] != int64(len(rows)) {
+		t.Fatalf("row groups %v, want one of %d rows", groups, len(rows))
+	}
+	chunk := group[1].([]interface{})[0].(map[int16]interface{})[3].(map[int16]interface{})
+	if !reflect.DeepEqual(chunk[3], []interface{}{"seq"}) || chunk[5] != int64(len(rows)) {
+		t.Errorf("first column chunk has path %v and %v values", chunk[3], chunk[5])
+	}
+	page := bytes.NewReader(data[chunk[9].(int64) : chunk[9].(int64)+chunk[6].(int64)])
+	header, err := readThriftStruct(page)
+	if err != nil {
+		t.Fatalf("cannot decode the page header: %v", err)
+	}
+	if dataPage, ok := header[5].(map[int16]interface{}); header[1] != int64(PARQUET_DATA_PAGE) || !ok ||
+		dataPage[1] != int64(len(rows)) {
+		t.Errorf("page header %v, want a data page of %d values", header, len(rows))
+	}
+	for _, row := range rows {
+		var seq int64
+		if err := binary.Read(page, binary.LittleEndian, &seq); err != nil || seq != row.Seq {
+			t.Errorf("seq column holds %d (%v), want %d", seq, err, row.Seq)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package probe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/sciond"
+	"github.com/scionproto/scion/go/lib/spath/spathmeta"
+)
+
+// Reports whether any hop of the path lies in the given ISD
+func pathTransitsISD(entry *sciond.PathReplyEntry, isd addr.ISD) bool {
+	for i := range entry.Path.Interfaces {
+		if entry.Path.Interfaces[i].ISD_AS().I == isd {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of interface hops on the path, two for each link between ASes as it is left through one
+// interface and entered through another
+func pathHops(entry *sciond.PathReplyEntry) int {
+	return len(entry.Path.Interfaces)
+}
+
+// Drops the paths with more than maxHops interface hops, failing with the shortest hop count if none
+// remain
+func filterMaxHops(options spathmeta.AppPathSet, maxHops int) (spathmeta.AppPathSet, error) {
+	compliant := make(spathmeta.AppPathSet)
+	shortest := -1
+	for key, option := range options {
+		hops := pathHops(option.Entry)
+		if hops <= maxHops {
+			compliant[key] = option
+		}
+		if shortest < 0 || hops < shortest {
+			shortest = hops
+		}
+	}
+	if len(compliant) == 0 {
+		return nil, &MeasurementError{Kind: ErrNoPath, Err: fmt.Errorf(
+			"Error, all %d paths exceed %d interface hops, the shortest has %d", len(options), maxHops, shortest)}
+	}
+	return compliant, nil
+}
+
+// Path policy of Config.Policy, a comma separated list of terms that a path must all match:
+//
+//	+ISD, -ISD            the path transits (+) or avoids (-) the ISD, e.g. +1 or -2
+//	+IA, -IA              the path transits or avoids the AS, e.g. -1-ff00:0:110
+//	+IA>IA..., -IA>IA...  the path does or does not traverse these ASes one right after the other
+//	hops<=N, hops>=N      the path has at most or at least N interface hops, see pathHops
+type PathPolicy struct {
+	expr  string
+	terms []policyTerm
+}
+
+type policyTerm struct {
+	include bool      // Whether a path must match (+) or must not match (-) isd or ases
+	isd     addr.ISD  // 0 for a term on ASes
+	ases    []addr.IA // Consecutive ASes on the path, a single one for a term on one AS
+	hopsOp  string    // "<=" or ">=" for a term on the hop count
+	hops    int
+}
+
+func ParsePathPolicy(expr string) (*PathPolicy, error) {
+	policy := &PathPolicy{expr: expr}
+	for _, field := range strings.Split(expr, ",") {
+		field = strings.TrimSpace(field)
+		var term policyTerm
+		switch {
+		case strings.HasPrefix(field, "hops<=") || strings.HasPrefix(field, "hops>="):
+			hops, err := strconv.Atoi(field[len("hops<="):])
+			if err != nil || hops < 0 {
+				return nil, fmt.Errorf("Error, invalid hop count in path policy term %q", field)
+			}
+			term.hopsOp, term.hops = field[len("hops"):len("hops<=")], hops
+		case strings.HasPrefix(field, "+") || strings.HasPrefix(field, "-"):
+			term.include = field[0] == '+'
+			target := field[1:]
+			if isd, err := strconv.ParseUint(target, 10, 16); err == nil && isd > 0 {
+				term.isd = addr.ISD(isd)
+				break
+			}
+			for _, as := range strings.Split(target, ">") {
+				ia, err := addr.IAFromString(as)
+				if err != nil {
+					return nil, fmt.Errorf("Error, invalid ISD or AS %q in path policy term %q", as, field)
+				}
+				term.ases = append(term.ases, ia)
+			}
+		default:
+			return nil, fmt.Errorf("Error, invalid path policy term %q, expected +/-ISD, +/-IA[>IA...], "+
+				"hops<=N or hops>=N", field)
+		}
+		policy.terms = append(policy.terms, term)
+	}
+	return policy, nil
+}
+
+// The policy as it was given to ParsePathPolicy
+func (p *PathPolicy) String() string {
+	return p.expr
+}
+
+// ASes the path traverses in order, starting with the source AS
+func pathASes(entry *sciond.PathReplyEntry) []addr.IA {
+	var ases []addr.IA
+	for i := range entry.Path.Interfaces {
+		ia := entry.Path.Interfaces[i].ISD_AS()
+		if len(ases) == 0 || !ases[len(ases)-1].Eq(ia) {
+			ases = append(ases, ia)
+		}
+	}
+	return ases
+}
+
+func (t *policyTerm) matches(entry *sciond.PathReplyEntry) bool {
+	switch {
+	case t.hopsOp == "<=":
+		return pathHops(entry) <= t.hops
+	case t.hopsOp == ">=":
+		return pathHops(entry) >= t.hops
+	case t.isd != 0:
+		return pathTransitsISD(entry, t.isd) == t.include
+	}
+	ases := pathASes(entry)
+	for i := 0; i+len(t.ases) <= len(ases); i++ {
+		traversed := true
+		for j := range t.ases {
+			if !ases[i+j].Eq(t.ases[j]) {
+				traversed = false
+				break
+			}
+		}
+		if traversed {
+			return t.include
+		}
+	}
+	return !t.include
+}
+
+// Drops the paths that do not match every term of the policy, failing if none remain
+func (p *PathPolicy) filter(options spathmeta.AppPathSet) (spathmeta.AppPathSet, error) {
+	compliant := make(spathmeta.AppPathSet)
+	for key, option := range options {
+		matched := true
+		for i := range p.terms {
+			if !p.terms[i].matches(option.Entry) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			compliant[key] = option
+		}
+	}
+	if len(compliant) == 0 {
+		return nil, &MeasurementError{Kind: ErrNoPath, Err: fmt.Errorf(
+			"Error, none of the %d paths matches the path policy %q", len(options), p.expr)}
+	}
+	return compliant, nil
+}
+
+// Picks a path that transits preferISD and avoids avoidISD (0 for no preference), falling back to
+// an arbitrary path if none qualifies. Also returns why the path was chosen.
+func selectPath(options spathmeta.AppPathSet, preferISD addr.ISD, avoidISD addr.ISD) (*sciond.PathReplyEntry, string) {
+	var fallback *sciond.PathReplyEntry
+	for _, entry := range options {
+		if fallback == nil {
+			fallback = entry.Entry
+		}
+		if preferISD != 0 && !pathTransitsISD(entry.Entry, preferISD) {
+			continue
+		}
+		if avoidISD != 0 && pathTransitsISD(entry.Entry, avoidISD) {
+			continue
+		}
+		switch {
+		case preferISD != 0 && avoidISD != 0:
+			return entry.Entry, fmt.Sprintf("transits ISD %d and avoids ISD %d", preferISD, avoidISD)
+		case preferISD != 0:
+			return entry.Entry, fmt.Sprintf("transits ISD %d", preferISD)
+		case avoidISD != 0:
+			return entry.Entry, fmt.Sprintf("avoids ISD %d", avoidISD)
+		}
+		return entry.Entry, "first path returned by the resolver"
+	}
+	return fallback, "no path matches the ISD preference, using the first path returned by the resolver"
+}
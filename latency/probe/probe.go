@@ -0,0 +1,413 @@
+// Package probe measures the RTT to a SCION host with SCMP echo requests, or with UDP probes that
+// dataplane_server echoes back. A Prober keeps the socket and the path to one destination, so that
+// it can be probed repeatedly; Result summarizes the RTT samples of a measurement.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/common"
+	"github.com/scionproto/scion/go/lib/overlay"
+	"github.com/scionproto/scion/go/lib/sciond"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/sock/reliable"
+	"github.com/scionproto/scion/go/lib/spath/spathmeta"
+)
+
+const (
+	MODE_SCMP = "scmp"
+	MODE_UDP = "udp"
+
+	CLOCK_MONOTONIC = "monotonic"
+	CLOCK_WALL = "wall"
+
+	// How a reply was matched to its probe, by its Id, by Id and Seq (Config.FixedId) or by Seq alone
+	// (Config.LooseId)
+	MATCH_ID = "id"
+	MATCH_SEQ = "seq"
+	MATCH_LOOSE = "loose"
+
+	DEFAULT_TIMEOUT = 2 * time.Second
+	DEFAULT_DISPATCHER = "/run/shm/dispatcher/default.sock"
+
+	// Probes are forgotten this long after they were sent, later replies count as discarded
+	LATE_HORIZON = 60 * time.Second
+)
+
+// Configuration of a Prober, the zero value probes the destination host with SCMP echo requests
+// and DEFAULT_TIMEOUT over the path sciond lists first
+type Config struct {
+	Mode       string        // MODE_SCMP or MODE_UDP
+	Timeout    time.Duration // Of a probe's reply
+	Size       int           // Of the probes' payload, padded in whole lines for SCMP
+	StrictSrc  bool          // Reject replies from another host than the destination
+	ToBorder   bool          // Probe the border router of the destination AS instead of the host (SCMP)
+	FixedId    bool          // Send every echo request with Id instead of a random one
+	Id         uint64
+	Ids        *IdRange    // Draw the random echo request Ids from this range if set
+	IdSource   rand.Source // Of the Ids drawn from Ids, the package's own if nil. Share it only with Ids.
+	LooseId    bool     // Accept an echo reply with the right Seq but another Id
+	Seq        uint16   // Sequence number of the first echo request, it wraps around after 65535
+	Ext        string   // Hop-by-hop extension the echo requests carry, a key of EXTENSIONS
+	Checksum   bool     // Count the replies whose payload differs from the request's, see Stats
+	FixedPort  bool     // The port of the local address must be bound, instead of falling back to another
+	MaxHops    int      // Only use paths with at most this many interface hops, 0 for any path
+	Policy     *PathPolicy
+	PreferISD  addr.ISD
+	AvoidISD   addr.ISD
+	Path       *sciond.PathReplyEntry // Used as is instead of resolving a path if set
+	Dispatcher string                 // Socket of the dispatcher, the default one if empty
+	Clock      string                 // CLOCK_MONOTONIC or CLOCK_WALL to time the probes with
+}
+
+func (c Config) withDefaults() Config {
+	if len(c.Mode) == 0 {
+		c.Mode = MODE_SCMP
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DEFAULT_TIMEOUT
+	}
+	if len(c.Dispatcher) == 0 {
+		c.Dispatcher = DEFAULT_DISPATCHER
+	}
+	if len(c.Clock) == 0 {
+		c.Clock = CLOCK_MONOTONIC
+	}
+	return c
+}
+
+// One probe and its reply. Sent and Bytes are set once the probe was built, the other fields only
+// if a reply was received.
+type Reply struct {
+	Sent     time.Time
+	Received time.Time
+	Bytes    int    // Of the probe as written to the socket
+	Source   string // IA and host the reply came from
+	Match    string // How the reply was matched to the probe, one of the MATCH_ constants
+	Path     string // Fingerprint of the path the reply took, see PathFingerprint
+	Hop      string // IA#IfID of the router that answered a probe to the border
+}
+
+func (r Reply) RTT() time.Duration {
+	return r.Received.Sub(r.Sent)
+}
+
+// How the path of a Prober was chosen
+type Selection struct {
+	Loaded   bool   // Config.Path was used as is
+	Resolved int    // Paths the control plane offered with at most Config.MaxHops interface hops
+	Matching int    // Paths of those matching Config.Policy, which the path was chosen from
+	Reason   string // What decided the choice
+}
+
+// Counters of a Prober since it was created or its stats were reset
+type Stats struct {
+	BytesSent int64
+	Discarded int     // Packets received while waiting for a reply that were not it
+	Corrupted int     // Replies whose payload checksum did not match the request's, see Config.Checksum
+	Late      []int64 // RTTs (in ns) of replies that arrived after their probe timed out
+}
+
+// Prober keeps a registered socket and a resolved path to one destination, so that long-lived
+// callers can probe repeatedly without setting them up again. snet must be initialized before New.
+// Its methods may be called from several goroutines, probes are then sent one at a time since they
+// share the socket and buffers.
+type Prober struct {
+	mu        sync.Mutex
+	sess      *session
+	config    Config
+	selection Selection
+	closed    bool
+}
+
+func New(local *snet.Addr, remote *snet.Addr, config Config) (*Prober, error) {
+	config = config.withDefaults()
+	if config.Mode != MODE_SCMP && config.Mode != MODE_UDP {
+		return nil, fmt.Errorf("Error, unknown mode %q, expected %s or %s", config.Mode, MODE_SCMP, MODE_UDP)
+	}
+	if len(config.Ext) > 0 && EXTENSIONS[config.Ext] == nil {
+		return nil, fmt.Errorf("Error, unknown extension %q", config.Ext)
+	}
+	sess := &session{
+		local:     local.Copy(),
+		remote:    remote.Copy(),
+		mode:      config.Mode,
+		clock:     config.Clock,
+		timeout:   config.Timeout,
+		toBorder:  config.ToBorder,
+		size:      config.Size,
+		strictSrc: config.StrictSrc,
+		fixedId:   config.FixedId,
+		id:        config.Id,
+		ids:       config.Ids,
+		idSource:  config.IdSource,
+		looseId:   config.LooseId,
+		seq:       config.Seq - 1,
+		ext:       config.Ext,
+		checksum:  config.Checksum,
+		fixedPort: config.FixedPort,
+	}
+	selection, err := sess.open(config)
+	if err != nil {
+		sess.close()
+		return nil, err
+	}
+	return &Prober{sess: sess, config: config, selection: selection}, nil
+}
+
+// Checks that a probe can be sent, p.mu must be held
+func (p *Prober) ready(ctx context.Context) error {
+	if p.closed {
+		return fmt.Errorf("Error, probe on a closed Prober")
+	}
+	return ctx.Err()
+}
+
+// Shortens the timeout of the probes to the deadline of ctx if that is earlier, the returned
+// function restores it
+func (p *Prober) shortenTimeout(ctx context.Context) func() {
+	timeout := p.sess.timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		p.sess.timeout = time.Until(deadline)
+	}
+	return func() { p.sess.timeout = timeout }
+}
+
+func (p *Prober) exchange(ctx context.Context) (Reply, error) {
+	if err := p.ready(ctx); err != nil {
+		return Reply{}, err
+	}
+	defer p.shortenTimeout(ctx)()
+	return p.sess.probe()
+}
+
+// Sends one probe and waits for its reply until the configured timeout or the deadline of ctx,
+// whichever is earlier, but a cancellation of ctx only takes effect before it is sent. An error
+// means the probe failed (no reply in time, a malformed reply or an impossible RTT) and can be
+// retried, unless it is ErrTooLarge: no probe of this size fits the path.
+func (p *Prober) Exchange(ctx context.Context) (Reply, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exchange(ctx)
+}
+
+// Sends one probe like Exchange and returns its RTT as a Result of one sample
+func (p *Prober) Probe(ctx context.Context) (Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reply, err := p.exchange(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	result := NewResult(p.sess.local.String(), p.sess.remote.String(),
+		[]Sample{{Seq: 1, RTT: int64(reply.RTT()), Sent: reply.Sent.UnixNano()}})
+	result.Mode = p.sess.mode
+	result.ToBorder = p.sess.toBorder
+	result.Size = p.sess.size
+	result.BytesSent = int64(reply.Bytes)
+	result.Clock = p.sess.clock
+	return *result, nil
+}
+
+// Offsets of the routing hop fields of the path in use, in path order, see ProbeHop
+func (p *Prober) HopOffsets() ([]uint8, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return hopFieldOffsets(p.sess.remote.Path)
+}
+
+// Sends a traceroute request to the router owning the hop field at offset and waits for its reply
+// like Exchange. The routers' IAs are not known in advance, so the reply is accepted from any
+// source; its Hop is the interface that answered.
+func (p *Prober) ProbeHop(ctx context.Context, offset uint8) (Reply, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.sess
+	toBorder, strictSrc, borderHopOff := s.toBorder, s.strictSrc, s.borderHopOff
+	s.toBorder, s.strictSrc, s.borderHopOff = true, false, offset
+	defer func() { s.toBorder, s.strictSrc, s.borderHopOff = toBorder, strictSrc, borderHopOff }()
+	return p.exchange(ctx)
+}
+
+// Configuration the Prober was created with, with the defaults filled in
+func (p *Prober) Config() Config {
+	return p.config
+}
+
+func (p *Prober) Selection() Selection {
+	return p.selection
+}
+
+func (p *Prober) Local() *snet.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.local.Copy()
+}
+
+// Address of the destination, with the path the probes are sent over
+func (p *Prober) Remote() *snet.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.remote.Copy()
+}
+
+// Local address the socket is bound to, with the port the dispatcher assigned
+func (p *Prober) Bound() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.bound
+}
+
+// Path the probes are sent over
+func (p *Prober) Path() *sciond.PathReplyEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.pathEntry
+}
+
+// Paths to the destination the path was selected from, nil for a path given with Config.Path
+func (p *Prober) Paths() spathmeta.AppPathSet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.paths
+}
+
+// Sends all further probes over the given path, e.g. one of Paths
+func (p *Prober) UsePath(pathEntry *sciond.PathReplyEntry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.usePath(pathEntry)
+}
+
+// MTU of the path in use
+func (p *Prober) MTU() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.mtu
+}
+
+// Expiration time of the path in use, zero if sciond did not report one
+func (p *Prober) PathExpiry() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.pathExpiry()
+}
+
+func (p *Prober) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.size
+}
+
+// Changes the payload size of further probes, a size beyond the path MTU fails them with ErrTooLarge
+func (p *Prober) SetSize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sess.size = size
+}
+
+// Largest payload size up to the configured one whose probes fit the path MTU, 0 if not even a
+// probe without padding does
+func (p *Prober) FitSize() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.fitSize()
+}
+
+// Sets the kernel buffer sizes of the socket to the dispatcher (SCMP only), which the replies are
+// queued in, and returns the sizes the kernel applied. Linux doubles the requested size and clamps
+// it to net.core.[rw]mem_max.
+func (p *Prober) SetBufferSizes(sndbuf int, rcvbuf int) (int, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.setBufferSizes(sndbuf, rcvbuf)
+}
+
+// Writes every packet sent and received from now on to w, nil to stop. Errors of the writes do
+// not fail the probes, the caller checks w.Err.
+func (p *Prober) SetCapture(w *CaptureWriter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sess.capture = w
+}
+
+func (p *Prober) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		BytesSent: p.sess.bytesSent,
+		Discarded: p.sess.discarded,
+		Corrupted: p.sess.corrupted,
+		Late:      append([]int64(nil), p.sess.late...),
+	}
+}
+
+// Zeroes the stats and forgets the probes still waiting for a reply, so that a late reply to one
+// of them is discarded rather than counted as late
+func (p *Prober) ResetStats() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sess.bytesSent = 0
+	p.sess.discarded = 0
+	p.sess.corrupted = 0
+	p.sess.late = nil
+	p.sess.outstanding = nil
+}
+
+// Releases the socket, later probes fail. Closing twice is harmless.
+func (p *Prober) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.sess.close()
+	}
+	return nil
+}
+
+// Estimates the local overhead (syscalls, dispatcher and scheduling) included in every RTT as the
+// median RTT (in ns) of echo requests to the local host, which the dispatcher answers without the
+// packets leaving the host. Overhead that only occurs on the network path, such as the overlay
+// socket of the border router, is not captured, so the estimate is a lower bound. Of config only
+// the timeout, dispatcher and clock are used.
+func Calibrate(local *snet.Addr, config Config, probes int) (int64, error) {
+	config = config.withDefaults()
+	self := local.Copy()
+	self.L4Port = 0
+	s := &session{
+		local:     self,
+		remote:    local.Copy(),
+		mode:      MODE_SCMP,
+		clock:     config.Clock,
+		timeout:   config.Timeout,
+		strictSrc: true,
+	}
+	if err := s.register(config.Dispatcher); err != nil {
+		return 0, err
+	}
+	defer s.close()
+	s.remote.Path = nil
+	s.remoteAppAddr = &reliable.AppAddr{Addr: s.remote.Host, Port: overlay.EndhostPort}
+	s.mtu = common.MinMTU
+	s.sendBuff = make(common.RawBytes, common.MinMTU)
+	s.recvBuff = make(common.RawBytes, common.MinMTU)
+
+	var rtts []int64
+	for i := 0; i < probes; i++ {
+		reply, err := s.probe()
+		if err == nil {
+			rtts = append(rtts, int64(reply.RTT()))
+		}
+	}
+	if len(rtts) == 0 {
+		return 0, fmt.Errorf("Error, no reply to any of the %d calibration probes to %s", probes, local.Host)
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	return Percentile(rtts, 50), nil
+}
@@ -0,0 +1,425 @@
+package probe
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/sciond"
+)
+
+const (
+	// Real paths vary by more than this over as many samples, a narrower spread suggests the replies
+	// come from a local loopback, a caching middlebox or a synthetic responder
+	CONSTANT_RTT_SPREAD = time.Microsecond
+	CONSTANT_RTT_SAMPLES = 20
+)
+
+// Two-sided 95% critical values of Student's t-distribution, indexed by degrees of freedom - 1
+var T_CRITICAL_95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// Summary of a measurement, as the command prints it with -json. All times are in milliseconds.
+type Result struct {
+	Source           string             `json:"source"`
+	Destination      string             `json:"destination"`
+	Mode             string             `json:"mode"`
+	Bound            string             `json:"bound,omitempty"`
+	ToBorder         bool               `json:"to_border"`
+	Samples          int                `json:"samples"`
+	BytesSent        int64              `json:"bytes_sent"`
+	Duration         float64            `json:"duration"`
+	Approximate      bool               `json:"approximate"`
+	ConstantRTT      bool               `json:"constant_rtt,omitempty"`
+	RTTAvg           float64            `json:"rtt_avg"`
+	RTTMin           float64            `json:"rtt_min"`
+	RTTMax           float64            `json:"rtt_max"`
+	RTTMinSeq        int                `json:"rtt_min_seq"`
+	RTTMaxSeq        int                `json:"rtt_max_seq"`
+	Range            float64            `json:"range"`
+	StdErr           *float64           `json:"stderr"`
+	CI95             *float64           `json:"ci95"`
+	Latency          *float64           `json:"latency,omitempty"`
+	Size             int                `json:"size"`
+	SizeLimit        *SizeLimit         `json:"size_limit,omitempty"`
+	MTU              int                `json:"mtu,omitempty"`
+	SmallRTTAvg      *float64           `json:"small_rtt_avg,omitempty"`
+	PerByte          *float64           `json:"per_byte_us,omitempty"`
+	RTTTrend         *float64           `json:"rtt_trend"`
+	Loss             *float64           `json:"loss,omitempty"`
+	PathExpiry       string             `json:"path_expiry,omitempty"`
+	Seconds          []*SecondStats     `json:"seconds,omitempty"`
+	Cmdline          string             `json:"cmdline,omitempty"`
+	Overhead         *float64           `json:"overhead,omitempty"`
+	Adjusted         *AdjustedRTT       `json:"adjusted,omitempty"`
+	Baseline         *Comparison        `json:"baseline,omitempty"`
+	Responders       []*ResponderStats  `json:"responders,omitempty"`
+	Switches         *int               `json:"responder_switches,omitempty"`
+	Paths            []*PathResult      `json:"paths,omitempty"`
+	Late             *LateStats         `json:"late,omitempty"`
+	Corrupted        *int               `json:"corrupted,omitempty"`
+	PoissonRate      *float64           `json:"poisson_rate,omitempty"`
+	Seed             *int64             `json:"seed,omitempty"` // Of the random gaps and sample reservoir
+	SkippedTicks     *int               `json:"skipped_ticks,omitempty"`
+	Trim             float64            `json:"trim,omitempty"`
+	RTTTrimmed       *float64           `json:"rtt_trimmed_avg,omitempty"`
+	RTTs             []float64          `json:"rtts,omitempty"`
+	Timestamps       []int64            `json:"timestamps_ns,omitempty"`
+	SamplesTruncated bool               `json:"samples_truncated,omitempty"`
+	RateLimit        *RateLimit         `json:"rate_limit,omitempty"`
+	Clock            string             `json:"clock"`
+	Stability        *Stability         `json:"stability,omitempty"`
+	MeasuredWindow   *MeasuredWindow    `json:"measured_window,omitempty"`
+	DecayHalfLife    float64            `json:"decay_half_life,omitempty"`
+	RTTAvgUnweighted *float64           `json:"rtt_avg_unweighted,omitempty"`
+	Extension        string             `json:"extension,omitempty"`
+	LoadCorrelation  *float64           `json:"load_correlation,omitempty"`
+	ReplyPaths       []*ReplyPathResult `json:"reply_paths,omitempty"`
+	IdCollisions     *int               `json:"id_collisions,omitempty"`
+	Breaches         []string           `json:"breaches,omitempty"`
+	SLO              *SLOReport         `json:"slo,omitempty"`
+	SLOMet           *bool              `json:"slo_met,omitempty"`
+}
+
+// Replies that arrived after their probe had timed out, with their true RTTs in ms. They are
+// not samples, a path with many of them is slow rather than lossy.
+type LateStats struct {
+	Replies   int     `json:"replies"`
+	RTTMin    float64 `json:"rtt_min"`
+	RTTMedian float64 `json:"rtt_median"`
+	RTTMax    float64 `json:"rtt_max"`
+}
+
+// RTTs of the probes sent in one second of a -duration run, in ms and null without replies. The
+// last second is partial if the run ended before it did.
+type SecondStats struct {
+	Second  int      `json:"second"`
+	Sent    int      `json:"sent"`
+	Replies int      `json:"replies"`
+	RTTMin  *float64 `json:"rtt_min"`
+	RTTAvg  *float64 `json:"rtt_avg"`
+	RTTMax  *float64 `json:"rtt_max"`
+	Partial bool     `json:"partial,omitempty"`
+
+	rtts []int64
+}
+
+// Adds a probe sent at offset from the start of the run, rtt is ignored if the probe failed
+func AddToSeries(series []*SecondStats, offset time.Duration, rtt int64, failed bool) []*SecondStats {
+	second := int(offset / time.Second)
+	for len(series) <= second {
+		series = append(series, &SecondStats{Second: len(series)})
+	}
+	series[second].Sent += 1
+	if !failed {
+		series[second].Replies += 1
+		series[second].rtts = append(series[second].rtts, rtt)
+	}
+	return series
+}
+
+// Fills in the statistics of every second, elapsed is the length of the run
+func FinishSeries(series []*SecondStats, elapsed time.Duration) {
+	for _, stats := range series {
+		if len(stats.rtts) > 0 {
+			mean, _ := MeanAndStdErr(stats.rtts)
+			min, max := MinMax(stats.rtts)
+			avg, fmin, fmax := mean/1e6, float64(min)/1e6, float64(max)/1e6
+			stats.RTTAvg, stats.RTTMin, stats.RTTMax = &avg, &fmin, &fmax
+		}
+	}
+	if len(series) > 0 && elapsed < time.Duration(len(series))*time.Second {
+		series[len(series)-1].Partial = true
+	}
+}
+
+// RTTs with the calibrated local overhead subtracted, in ms
+type AdjustedRTT struct {
+	RTTAvg float64 `json:"rtt_avg"`
+	RTTMin float64 `json:"rtt_min"`
+	RTTMax float64 `json:"rtt_max"`
+}
+
+// Change from a -baseline result, RTTs are in ms and loss in percent. A regression is an average
+// RTT more than the threshold percent above the baseline, or loss more than the threshold higher.
+type Comparison struct {
+	File       string   `json:"file"`
+	RTTAvg     float64  `json:"rtt_avg"`
+	RTTDelta   float64  `json:"rtt_delta"`
+	RTTChange  float64  `json:"rtt_change"`
+	Loss       *float64 `json:"loss,omitempty"`
+	LossDelta  *float64 `json:"loss_delta,omitempty"`
+	Threshold  float64  `json:"threshold"`
+	Regression bool     `json:"regression"`
+}
+
+// Replies of one responder to -responders probes, share is in percent of all replies and the RTT in ms
+type ResponderStats struct {
+	Responder string  `json:"responder"`
+	Replies   int     `json:"replies"`
+	Share     float64 `json:"share"`
+	RTTAvg    float64 `json:"rtt_avg"`
+
+	rttSum int64
+}
+
+// Tallies which responder answered each probe and how often consecutive replies came from
+// different ones, which shows an anycast destination flapping between instances. The zero value
+// is an empty tally.
+type ResponderTally struct {
+	byName   map[string]*ResponderStats
+	order    []*ResponderStats
+	last     string
+	switches int
+}
+
+func (t *ResponderTally) Add(responder string, rtt int64) {
+	if t.byName == nil {
+		t.byName = make(map[string]*ResponderStats)
+	}
+	stats, ok := t.byName[responder]
+	if !ok {
+		stats = &ResponderStats{Responder: responder}
+		t.byName[responder] = stats
+		t.order = append(t.order, stats)
+	}
+	stats.Replies += 1
+	stats.rttSum += rtt
+	if len(t.last) > 0 && t.last != responder {
+		t.switches += 1
+	}
+	t.last = responder
+}
+
+// Responders by number of replies, most first
+func (t *ResponderTally) Stats(replies int) []*ResponderStats {
+	for _, stats := range t.order {
+		stats.Share = 100 * float64(stats.Replies) / float64(replies)
+		stats.RTTAvg = float64(stats.rttSum) / float64(stats.Replies) / 1e6
+	}
+	sort.SliceStable(t.order, func(i, j int) bool { return t.order[i].Replies > t.order[j].Replies })
+	return t.order
+}
+
+// Number of replies that came from another responder than the one before
+func (t *ResponderTally) Switches() int {
+	return t.switches
+}
+
+// RTT sample of one successful probe
+type Sample struct {
+	Seq  int   // Number of the probe in the run, counting failed probes, from 1
+	RTT  int64 // In ns
+	Sent int64 // Unix time in ns the probe was sent
+}
+
+// Sets the RTTs (in ms) of the samples and the Unix times (in ns) their probes were sent, in probe
+// order. With more than max samples (0 for no limit) only the first and last max/2 are kept.
+func (r *Result) SetTimestampsDetail(samples []Sample, max int) {
+	ordered := make([]Sample, len(samples))
+	copy(ordered, samples)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Seq < ordered[j].Seq })
+	if max > 0 && len(ordered) > max {
+		ordered = append(ordered[:(max+1)/2], ordered[len(ordered)-max/2:]...)
+		r.SamplesTruncated = true
+	}
+	r.RTTs = make([]float64, len(ordered))
+	r.Timestamps = make([]int64, len(ordered))
+	for i, s := range ordered {
+		r.RTTs[i] = float64(s.RTT) / 1e6
+		r.Timestamps[i] = s.Sent
+	}
+}
+
+// RTTs (in ns) of the samples, in their order
+func RTTs(samples []Sample) []int64 {
+	rtts := make([]int64, len(samples))
+	for i, s := range samples {
+		rtts[i] = s.RTT
+	}
+	return rtts
+}
+
+// A -size that did not fit the path MTU: the largest size that does and whether the probes were
+// clamped to it (-clamp-size) or the run failed
+type SizeLimit struct {
+	Requested int    `json:"requested"`
+	MTU       int    `json:"mtu"`
+	Effective int    `json:"effective"`
+	Outcome   string `json:"outcome"`
+}
+
+const (
+	SIZE_CLAMPED = "clamped"
+	SIZE_FAILED = "failed"
+)
+
+// Result of measuring one path with -probe-all-paths, the RTT is in ms and null without replies
+type PathResult struct {
+	Path    string   `json:"path"`
+	Sent    int      `json:"sent"`
+	Samples int      `json:"samples"`
+	RTTAvg  *float64 `json:"rtt_avg"`
+	Loss    float64  `json:"loss"`
+
+	Entry *sciond.PathReplyEntry `json:"-"`
+}
+
+// Outcome of -until-stable: whether the standard deviation of the last Window RTTs fell below the
+// threshold, after how many probes, and their mean and standard deviation in ms
+type Stability struct {
+	Stable bool    `json:"stable"`
+	Probes int     `json:"probes"`
+	Window int     `json:"window"`
+	RTTAvg float64 `json:"rtt_avg"`
+	StdDev float64 `json:"stddev"`
+}
+
+// Window of -measure-at: when it was scheduled and when probing actually started and ended, in UTC.
+// Warm-up probes are not part of the window or any statistics.
+type MeasuredWindow struct {
+	Scheduled string `json:"scheduled"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Warmup    int    `json:"warmup"`
+}
+
+// Statistics of the replies that took one path, identified by the FNV-1a hash of its raw bytes
+type ReplyPathResult struct {
+	Fingerprint string  `json:"fingerprint"`
+	Stats       *Result `json:"stats"`
+}
+
+// Loss pattern of a router rate limiting SCMP replies: period in s between the loss bursts and the
+// rate of replies per second let through
+type RateLimit struct {
+	Period float64 `json:"period"`
+	Rate   float64 `json:"rate"`
+}
+
+// How the result compares to the -slo, a positive margin is the budget left and a negative one
+// how far it was missed. The RTT is in ms and the loss in percent, 0 for no limit.
+type SLOReport struct {
+	RTT        float64  `json:"rtt,omitempty"`
+	RTTMargin  *float64 `json:"rtt_margin,omitempty"`
+	Loss       float64  `json:"loss,omitempty"`
+	LossMargin *float64 `json:"loss_margin,omitempty"`
+}
+
+// Reports whether neither the RTT nor the loss exceeded the SLO
+func (r *SLOReport) Met() bool {
+	return (r.RTTMargin == nil || *r.RTTMargin >= 0) && (r.LossMargin == nil || *r.LossMargin >= 0)
+}
+
+// Returns the mean and standard error of the mean of the samples (in ns)
+func MeanAndStdErr(samples []int64) (float64, float64) {
+	n := float64(len(samples))
+	var sum float64 = 0
+	for _, s := range samples {
+		sum += float64(s)
+	}
+	mean := sum / n
+	if len(samples) < 2 {
+		return mean, 0
+	}
+
+	var squares float64 = 0
+	for _, s := range samples {
+		squares += (float64(s) - mean) * (float64(s) - mean)
+	}
+	stddev := math.Sqrt(squares / (n - 1))
+	return mean, stddev / math.Sqrt(n)
+}
+
+// Half-width of the 95% confidence interval of the mean, using the t-distribution for small samples
+func ConfidenceInterval95(stderr float64, n int) float64 {
+	df := n - 1
+	t := 1.960
+	if df <= len(T_CRITICAL_95) {
+		t = T_CRITICAL_95[df-1]
+	}
+	return t * stderr
+}
+
+// Lowest and highest of the samples
+func MinMax(samples []int64) (int64, int64) {
+	min, max := samples[0], samples[0]
+	for _, s := range samples {
+		if s < min {
+			min = s
+		}
+		if s > max {
+			max = s
+		}
+	}
+	return min, max
+}
+
+// Least-squares slope of the samples (in ns) against their probe index, in ns per probe
+func TrendSlope(samples []int64) float64 {
+	n := float64(len(samples))
+	var sumX, sumY, sumXY, sumXX float64
+	for i, s := range samples {
+		x := float64(i)
+		sumX += x
+		sumY += float64(s)
+		sumXY += x * float64(s)
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+// Value below which p percent of the sorted samples fall, by the nearest-rank method
+func Percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Computes the summary statistics of the RTT samples, there must be at least one
+func NewResult(source string, destination string, samples []Sample) *Result {
+	rtts := RTTs(samples)
+	mean, stderr := MeanAndStdErr(rtts)
+	min, max := MinMax(rtts)
+	var minSeq, maxSeq int
+	for _, s := range samples {
+		if s.RTT == min && minSeq == 0 {
+			minSeq = s.Seq
+		}
+		if s.RTT == max && maxSeq == 0 {
+			maxSeq = s.Seq
+		}
+	}
+
+	// Print in ms, so divide by 1e6 from nano
+	result := &Result{
+		Source:      source,
+		Destination: destination,
+		Samples:     len(samples),
+		RTTAvg:      mean / 1e6,
+		RTTMin:      float64(min) / 1e6,
+		RTTMax:      float64(max) / 1e6,
+		RTTMinSeq:   minSeq,
+		RTTMaxSeq:   maxSeq,
+		Range:       float64(max-min) / 1e6,
+		ConstantRTT: len(samples) >= CONSTANT_RTT_SAMPLES && max-min < int64(CONSTANT_RTT_SPREAD),
+	}
+	latency := mean / 2e6
+	result.Latency = &latency
+	if len(samples) > 1 {
+		se := stderr / 1e6
+		ci := ConfidenceInterval95(stderr, len(samples)) / 1e6
+		result.StdErr = &se
+		result.CI95 = &ci
+	}
+	if len(samples) >= 3 {
+		trend := TrendSlope(rtts) * 100 / 1e6
+		result.RTTTrend = &trend
+	}
+	return result
+}
@@ -0,0 +1,602 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math/rand"
+	"syscall"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/common"
+	"github.com/scionproto/scion/go/lib/hpkt"
+	"github.com/scionproto/scion/go/lib/overlay"
+	"github.com/scionproto/scion/go/lib/sciond"
+	"github.com/scionproto/scion/go/lib/scmp"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/sock/reliable"
+	"github.com/scionproto/scion/go/lib/spath"
+	"github.com/scionproto/scion/go/lib/spath/spathmeta"
+	"github.com/scionproto/scion/go/lib/spkt"
+)
+
+// Connection state shared by the probes of a Prober
+type session struct {
+	local         *snet.Addr
+	remote        *snet.Addr
+	mode          string
+	clock         string
+	scmpConn      *reliable.Conn
+	remoteAppAddr *reliable.AppAddr
+	udpConn       *snet.Conn
+	sendBuff      common.RawBytes // Reused by every probe, reallocated only for a larger MTU or probe
+	recvBuff      common.RawBytes
+	mtu           int // Of the path in use
+	timeout       time.Duration
+	toBorder      bool
+	borderHopOff  uint8
+	size          int
+	strictSrc     bool
+	paths         spathmeta.AppPathSet
+	pathEntry     *sciond.PathReplyEntry
+	bound         string
+	bytesSent     int64
+	lastBytes     int
+	fixedId       bool // Send every echo request with id instead of a random Id
+	id            uint64
+	seq           uint16 // Sequence number of the last echo request, wraps around after 65535
+	lastHop       *scmp.InfoTraceRoute
+	looseId       bool                   // Accept an echo reply with the right Seq but another Id
+	lastMatch     string                 // How the last reply was matched, one of the MATCH_ constants
+	capture       *CaptureWriter         // Every packet sent and received is written to it if set
+	ext           string                 // Name of the hop-by-hop extension echo requests carry, see EXTENSIONS
+	lastReplyPath string                 // Fingerprint of the path the last reply took
+	ids           *IdRange               // Random echo request Ids are drawn from it if set
+	idSource      rand.Source            // Source the Ids are drawn from ids with, see IdRange.draw
+	discarded     int                    // Packets received while waiting for a reply that were not it
+	lastSource    string                 // IA and host the last reply came from
+	outstanding   map[probeKey]time.Time // Send times of the probes still waiting for a reply
+	late          []int64                // RTTs (in ns) of replies that arrived after their probe timed out
+	checksum      bool                   // Compare a CRC32 of every reply's payload with that of the request
+	sentSum       uint32                 // CRC32 of the payload of the last request
+	sentStamp     uint64                 // Timestamp of the SCMP header of the last request
+	corrupted     int                    // Replies whose payload checksum did not match the request's
+	fixedPort     bool                   // The local port must be bound as given, there is no fallback
+}
+
+// Time for timing a probe. time.Now carries a monotonic reading that Sub uses when both times have
+// one, so RTTs are immune to steps of the wall clock (NTP, leap seconds). Round(0) strips it, so
+// with CLOCK_WALL RTTs are differences of wall clock times and follow any step.
+func now(clock string) time.Time {
+	if clock == CLOCK_WALL {
+		return time.Now().Round(0)
+	}
+	return time.Now()
+}
+
+// Rejects an RTT that is not positive. Sub uses the monotonic clock readings, so this only happens
+// without them (CLOCK_WALL) if the wall clock stepped backwards between sent and received.
+func checkRTT(sent time.Time, received time.Time) error {
+	if rtt := received.Sub(sent); rtt <= 0 {
+		return fmt.Errorf("non-positive RTT of %s", rtt)
+	}
+	return nil
+}
+
+// Identifies a probe by its Id and, for echo requests sent with a fixed Id, its sequence number
+type probeKey struct {
+	id  uint64
+	seq uint16
+}
+
+// Remembers that the probe key was sent at time_sent and forgets probes older than LATE_HORIZON
+func (s *session) track(key probeKey, time_sent time.Time) {
+	if s.outstanding == nil {
+		s.outstanding = make(map[probeKey]time.Time)
+	}
+	for k, t := range s.outstanding {
+		if time_sent.Sub(t) > LATE_HORIZON {
+			delete(s.outstanding, k)
+		}
+	}
+	s.outstanding[key] = time_sent
+}
+
+// Records a reply to an earlier probe that already timed out, returns false if key is not one
+func (s *session) recordLate(key probeKey, time_received time.Time) bool {
+	time_sent, ok := s.outstanding[key]
+	if !ok {
+		return false
+	}
+	delete(s.outstanding, key)
+	s.late = append(s.late, time_received.Sub(time_sent).Nanoseconds())
+	return true
+}
+
+// Checks whether pkt is the echo reply to an earlier probe, see recordLate
+func (s *session) lateSCMP(pkt *spkt.ScnPkt, time_received time.Time) bool {
+	if s.toBorder {
+		return false
+	}
+	if s.strictSrc && validateSource(pkt.SrcIA, pkt.SrcHost, s.remote, true) != nil {
+		return false
+	}
+	_, info, err := validatePkt(pkt, 0)
+	if err != nil {
+		return false
+	}
+	return s.recordLate(probeKey{info.Id, info.Seq}, time_received)
+}
+
+// Sends one probe and waits for its reply. An error means the probe failed (no reply in time, a
+// malformed reply or an impossible RTT) and can be retried, unless it is ErrTooLarge.
+func (s *session) probe() (Reply, error) {
+	s.lastBytes = 0
+	var reply Reply
+	var err error
+	if s.mode == MODE_UDP {
+		reply.Sent, reply.Received, err = s.probeUDP()
+	} else {
+		reply.Sent, reply.Received, err = s.probeSCMP()
+	}
+	reply.Bytes = s.lastBytes
+	if err != nil {
+		return reply, err
+	}
+	if err := checkRTT(reply.Sent, reply.Received); err != nil {
+		log.Printf("Warning, discarding %v, did the clock go backwards?", err)
+		return reply, err
+	}
+	reply.Source, reply.Match, reply.Path = s.lastSource, s.lastMatch, s.lastReplyPath
+	if s.toBorder && s.lastHop != nil {
+		reply.Hop = fmt.Sprintf("%s#%d", s.lastHop.IA, s.lastHop.IfID)
+	}
+	return reply, nil
+}
+
+func (s *session) probeSCMP() (time.Time, time.Time, error) {
+	// Construct SCMP Packet
+	var id uint64
+	var pkt *spkt.ScnPkt
+	if s.toBorder {
+		id, pkt = createScmpTraceRouteReqPkt(s.local, s.remote, s.borderHopOff)
+	} else {
+		id = randomUint64()
+		if s.fixedId {
+			id = s.id
+		} else if s.ids != nil {
+			id = s.ids.draw(s.idSource)
+		}
+		s.seq += 1
+		var extensions []common.Extension
+		if len(s.ext) > 0 {
+			extensions = []common.Extension{EXTENSIONS[s.ext]()}
+		}
+		pkt = createScmpEchoReqPkt(s.local, s.remote, s.size, id, s.seq, extensions)
+		// The padding is quoted as L4 header, which the echo reply carries back unchanged
+		if s.checksum {
+			padding := pkt.Pld.(common.RawBytes)[scmp.MetaLen+(&scmp.InfoEcho{}).Len():]
+			fillRandom(padding)
+			s.sentSum = crc32.ChecksumIEEE(padding)
+		}
+	}
+	s.sentStamp = pkt.L4.(*scmp.Hdr).Timestamp
+	pktLen, err := serializeProbe(pkt, s.sendBuff)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	// Retrying is pointless, every probe of this size is too large for the path
+	if pktLen > s.mtu {
+		return time.Time{}, time.Time{}, &MeasurementError{Kind: ErrTooLarge,
+			Err: fmt.Errorf("Error, probe of %d bytes does not fit the path MTU of %d bytes", pktLen, s.mtu)}
+	}
+
+	time_sent := now(s.clock)
+	_, err = s.scmpConn.WriteTo(s.sendBuff[:pktLen], s.remoteAppAddr)
+	if err != nil {
+		return time_sent, time.Time{}, err
+	}
+	if s.capture != nil {
+		s.capture.Write(time_sent, false, s.sendBuff[:pktLen])
+	}
+	key := probeKey{id, s.seq}
+	if !s.toBorder {
+		s.track(key, time_sent)
+	}
+	s.bytesSent += int64(pktLen)
+	s.lastBytes = pktLen
+
+	// Packets that are not the reply are discarded until it arrives or the deadline passes
+	s.scmpConn.SetReadDeadline(time_sent.Add(s.timeout))
+	for {
+		n, err := s.scmpConn.Read(s.recvBuff)
+		time_received := now(s.clock)
+		if err != nil {
+			return time_sent, time_received, readError(err)
+		}
+		if s.capture != nil {
+			s.capture.Write(time_received, true, s.recvBuff[:n])
+		}
+
+		// A truncated or malformed packet cannot be told to be the reply, so it is discarded like
+		// any other and the reply may still arrive
+		if err := checkTruncated(s.recvBuff[:n]); err != nil {
+			s.discarded += 1
+			continue
+		}
+		recvpkt := &spkt.ScnPkt{}
+		if err := hpkt.ParseScnPkt(recvpkt, s.recvBuff[:n]); err != nil {
+			s.discarded += 1
+			continue
+		}
+		if err := s.matchSCMP(recvpkt, id); err != nil {
+			if errors.Is(err, ErrSCMPError) {
+				return time_sent, time_received, err
+			}
+			if !s.lateSCMP(recvpkt, time_received) {
+				s.discarded += 1
+			}
+			continue
+		}
+		delete(s.outstanding, key)
+		if s.checksum {
+			if pld, ok := recvpkt.Pld.(*scmp.Payload); !ok || crc32.ChecksumIEEE(pld.L4Hdr) != s.sentSum {
+				s.corrupted += 1
+			}
+		}
+		s.lastSource = fmt.Sprintf("%s,[%s]", recvpkt.SrcIA, recvpkt.SrcHost)
+		s.lastReplyPath = PathFingerprint(recvpkt.Path)
+		return time_sent, time_received, nil
+	}
+}
+
+// Checks that pkt is the reply to the request with the given Id. An SCMP error fails the probe if
+// it quotes the request, one about an earlier probe is discarded like its late reply would be.
+func (s *session) matchSCMP(pkt *spkt.ScnPkt, id uint64) error {
+	if hdr, ok := pkt.L4.(*scmp.Hdr); ok && hdr.Class != scmp.C_General {
+		if !s.quotesProbe(pkt, id) {
+			return fmt.Errorf("SCMP error class %v type %v about another packet", hdr.Class, hdr.Type)
+		}
+		return &MeasurementError{Kind: ErrSCMPError, Err: common.NewBasicError("SCMP error reply", nil,
+			"class", hdr.Class, "type", hdr.Type, "src", pkt.SrcIA)}
+	}
+	if s.strictSrc {
+		// Border routers answer from their own address, so only their IA can be checked
+		if err := validateSource(pkt.SrcIA, pkt.SrcHost, s.remote, !s.toBorder); err != nil {
+			log.Printf("Warning, rejected reply: %v", err)
+			return err
+		}
+	}
+	if s.toBorder {
+		_, info, err := validateTraceRoutePkt(pkt)
+		if err != nil {
+			return err
+		}
+		if info.Id != id {
+			return fmt.Errorf("reply Id %d does not match request Id %d", info.Id, id)
+		}
+		s.lastHop = info
+		s.lastMatch = MATCH_ID
+		return nil
+	}
+
+	_, info, err := validatePkt(pkt, id)
+	if err != nil {
+		return err
+	}
+	// With a fixed Id only the sequence number tells a late reply from the current one
+	if info.Seq != s.seq {
+		return fmt.Errorf("reply Seq %d does not match request Seq %d", info.Seq, s.seq)
+	}
+	switch {
+	case info.Id == id && s.fixedId:
+		s.lastMatch = MATCH_SEQ
+	case info.Id == id:
+		s.lastMatch = MATCH_ID
+	case s.looseId:
+		s.lastMatch = MATCH_LOOSE
+	default:
+		return fmt.Errorf("reply Id %d does not match request Id %d", info.Id, id)
+	}
+	return nil
+}
+
+// Tells whether the SCMP error pkt quotes the last request, sent with the given Id: by the Id and
+// Seq of the quoted Info, or by the timestamp of the quoted SCMP header if the quote ends before it
+func (s *session) quotesProbe(pkt *spkt.ScnPkt, id uint64) bool {
+	hdr, info, err := quotedRequest(pkt)
+	if err != nil || hdr.Class != scmp.C_General {
+		return false
+	}
+	if s.toBorder && len(info) >= (&scmp.InfoTraceRoute{}).Len() {
+		quoted, err := scmp.InfoTraceRouteFromRaw(info)
+		return err == nil && hdr.Type == scmp.T_G_TraceRouteRequest && quoted.Id == id
+	}
+	if !s.toBorder && len(info) >= (&scmp.InfoEcho{}).Len() {
+		quoted, err := scmp.InfoEchoFromRaw(info)
+		return err == nil && hdr.Type == scmp.T_G_EchoRequest && quoted.Id == id && quoted.Seq == s.seq
+	}
+	return hdr.Timestamp == s.sentStamp
+}
+
+// Sends a random Id to the UDP responder (dataplane_server) and waits for it to be echoed back
+func (s *session) probeUDP() (time.Time, time.Time, error) {
+	id := randomUint64()
+	if s.size > s.mtu {
+		return time.Time{}, time.Time{}, &MeasurementError{Kind: ErrTooLarge,
+			Err: fmt.Errorf("Error, payload of %d bytes does not fit the path MTU of %d bytes", s.size, s.mtu)}
+	}
+	n := binary.PutUvarint(s.sendBuff, id)
+	// Clear the padding, it still holds the previous probe's bytes
+	if n < s.size {
+		if s.checksum {
+			fillRandom(s.sendBuff[n:s.size])
+		} else {
+			for i := n; i < s.size; i++ {
+				s.sendBuff[i] = 0
+			}
+		}
+		n = s.size
+	}
+	if s.checksum {
+		s.sentSum = crc32.ChecksumIEEE(s.sendBuff[:n])
+	}
+
+	time_sent := now(s.clock)
+	_, err := s.udpConn.WriteToSCION(s.sendBuff[:n], s.remote)
+	if err != nil {
+		return time_sent, time.Time{}, err
+	}
+	if s.capture != nil {
+		s.capture.Write(time_sent, false, s.sendBuff[:n])
+	}
+	s.track(probeKey{id: id}, time_sent)
+	s.bytesSent += int64(n)
+	s.lastBytes = n
+
+	// Packets that are not the reply are discarded until it arrives or the deadline passes
+	s.udpConn.SetReadDeadline(time_sent.Add(s.timeout))
+	for {
+		n, from, err := s.udpConn.ReadFromSCION(s.recvBuff)
+		time_received := now(s.clock)
+		if err != nil {
+			return time_sent, time_received, readError(err)
+		}
+		if s.capture != nil {
+			s.capture.Write(time_received, true, s.recvBuff[:n])
+		}
+		if s.strictSrc {
+			if err := validateSource(from.IA, from.Host, s.remote, true); err != nil {
+				log.Printf("Warning, rejected reply: %v", err)
+				s.discarded += 1
+				continue
+			}
+		}
+
+		reply_id, m := binary.Uvarint(s.recvBuff[:n])
+		if m <= 0 || reply_id != id {
+			if m <= 0 || !s.recordLate(probeKey{id: reply_id}, time_received) {
+				s.discarded += 1
+			}
+			continue
+		}
+		delete(s.outstanding, probeKey{id: id})
+		if s.checksum && crc32.ChecksumIEEE(s.recvBuff[:n]) != s.sentSum {
+			s.corrupted += 1
+		}
+		s.lastMatch = MATCH_ID
+		s.lastSource = fmt.Sprintf("%s,[%s]", from.IA, from.Host)
+		s.lastReplyPath = PathFingerprint(from.Path)
+		return time_sent, time_received, nil
+	}
+}
+
+// Largest size up to the session's that fits the path MTU. SCMP probes are serialized to account
+// for the headers, their padding grows in lines of common.LineLen, so each try may overshoot the
+// excess and the next one is checked again.
+func (s *session) fitSize() (int, error) {
+	if s.mode == MODE_UDP || s.toBorder {
+		if s.size > s.mtu {
+			return s.mtu, nil
+		}
+		return s.size, nil
+	}
+	var extensions []common.Extension
+	if len(s.ext) > 0 {
+		extensions = []common.Extension{EXTENSIONS[s.ext]()}
+	}
+	size := s.size
+	for size > 0 {
+		pktLen, err := serializeProbe(createScmpEchoReqPkt(s.local, s.remote, size, 0, 0, extensions), s.sendBuff)
+		if err != nil {
+			return 0, err
+		}
+		if pktLen <= s.mtu {
+			return size, nil
+		}
+		size -= pktLen - s.mtu
+	}
+	return 0, nil
+}
+
+// Registers (SCMP) or dials (UDP) the socket of the session and resolves the path to the remote,
+// returning how it was chosen
+func (s *session) open(config Config) (Selection, error) {
+	var selection Selection
+	if s.mode == MODE_SCMP {
+		if err := s.register(config.Dispatcher); err != nil {
+			return selection, err
+		}
+	}
+
+	// A loaded path is used as is, even if the control plane offers others
+	if config.Path != nil {
+		selection.Loaded = true
+		if err := s.usePath(config.Path); err != nil {
+			return selection, err
+		}
+		return selection, s.dialUDP()
+	}
+
+	// Get Path to Remote
+	var options spathmeta.AppPathSet
+	options = snet.DefNetwork.PathResolver().Query(s.local.IA, s.remote.IA)
+	if len(options) == 0 {
+		return selection, &MeasurementError{Kind: ErrNoPath,
+			Err: fmt.Errorf("Cannot find a path from source to destination %s", s.remote)}
+	}
+
+	if config.MaxHops > 0 {
+		var err error
+		options, err = filterMaxHops(options, config.MaxHops)
+		if err != nil {
+			return selection, err
+		}
+	}
+	selection.Resolved = len(options)
+	if config.Policy != nil {
+		var err error
+		options, err = config.Policy.filter(options)
+		if err != nil {
+			return selection, err
+		}
+	}
+	selection.Matching = len(options)
+
+	var pathEntry *sciond.PathReplyEntry
+	pathEntry, selection.Reason = selectPath(options, config.PreferISD, config.AvoidISD)
+	s.paths = options
+	if err := s.usePath(pathEntry); err != nil {
+		return selection, err
+	}
+	return selection, s.dialUDP()
+}
+
+// The dispatcher assigns a random port if the source port is unspecified
+func (s *session) register(dispatcherAddr string) error {
+	localAppAddr := &reliable.AppAddr{Addr: s.local.Host, Port: s.local.L4Port}
+	scmpConn, boundPort, err := reliable.Register(dispatcherAddr, s.local.IA, localAppAddr, nil, addr.SvcNone)
+	if err != nil && s.fixedPort {
+		return fmt.Errorf("Error, cannot bind source port %d, is it in use? %v", s.local.L4Port, err)
+	}
+	if err != nil {
+		return err
+	}
+	s.scmpConn = scmpConn
+	if s.fixedPort && boundPort != s.local.L4Port {
+		return fmt.Errorf("Error, the dispatcher bound port %d instead of source port %d", boundPort,
+			s.local.L4Port)
+	}
+	s.bound = fmt.Sprintf("%s,[%s]:%d", s.local.IA, s.local.Host, boundPort)
+	return nil
+}
+
+// Sets the kernel buffer sizes of the socket to the dispatcher, which the replies are queued in, and
+// returns the sizes the kernel applied. Linux doubles the requested size and clamps it to
+// net.core.[rw]mem_max.
+func (s *session) setBufferSizes(sndbuf int, rcvbuf int) (int, int, error) {
+	if s.scmpConn == nil {
+		return 0, 0, fmt.Errorf("Error, socket buffer sizes can only be set in mode %s, "+
+			"snet does not expose the socket of mode %s", MODE_SCMP, MODE_UDP)
+	}
+	if sndbuf > 0 {
+		if err := s.scmpConn.SetWriteBuffer(sndbuf); err != nil {
+			return 0, 0, err
+		}
+	}
+	if rcvbuf > 0 {
+		if err := s.scmpConn.SetReadBuffer(rcvbuf); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	raw, err := s.scmpConn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var applied_snd, applied_rcv int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		applied_snd, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		if sockErr == nil {
+			applied_rcv, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		}
+	})
+	if err == nil {
+		err = sockErr
+	}
+	return applied_snd, applied_rcv, err
+}
+
+// The UDP socket is dialed over the selected path
+func (s *session) dialUDP() error {
+	if s.mode != MODE_UDP {
+		return nil
+	}
+	var err error
+	s.udpConn, err = snet.DialSCION("udp4", s.local, s.remote)
+	if err != nil && s.fixedPort {
+		return fmt.Errorf("Error, cannot bind source port %d, is it in use? %v", s.local.L4Port, err)
+	}
+	if err != nil {
+		return err
+	}
+	s.bound = s.udpConn.LocalSnetAddr().String()
+	return nil
+}
+
+func (s *session) close() {
+	if s.scmpConn != nil {
+		s.scmpConn.Close()
+	}
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+}
+
+// Sends all further probes of the session over the given path
+func (s *session) usePath(pathEntry *sciond.PathReplyEntry) error {
+	s.pathEntry = pathEntry
+	s.remote.Path = spath.New(pathEntry.Path.FwdPath)
+	s.remote.Path.InitOffsets()
+	s.remote.NextHopHost = pathEntry.HostInfo.Host()
+	s.remote.NextHopPort = pathEntry.HostInfo.Port
+	s.remoteAppAddr = &reliable.AppAddr{Addr: s.remote.NextHopHost, Port: s.remote.NextHopPort}
+	if s.remote.NextHopHost == nil {
+		s.remoteAppAddr = &reliable.AppAddr{Addr: s.remote.Host, Port: overlay.EndhostPort}
+	}
+	// Large enough for a probe that exceeds the MTU to be serialized and reported as such
+	s.mtu = int(pathEntry.Path.Mtu)
+	bufLen := s.mtu
+	if need := s.size + len(pathEntry.Path.FwdPath) + PROBE_HEADROOM; need > bufLen {
+		bufLen = need
+	}
+	if len(s.sendBuff) < bufLen {
+		s.sendBuff = make(common.RawBytes, bufLen)
+		s.recvBuff = make(common.RawBytes, bufLen)
+	}
+
+	// The last hop field of the path is the ingress of the destination AS
+	if s.toBorder {
+		offsets, err := hopFieldOffsets(s.remote.Path)
+		if err != nil {
+			return err
+		}
+		if len(offsets) == 0 {
+			return fmt.Errorf("Error, path has no hop fields, cannot reach a border router")
+		}
+		s.borderHopOff = offsets[len(offsets)-1]
+	}
+	return nil
+}
+
+// Expiration time of the path the probes are sent over, zero if sciond did not report one
+func (s *session) pathExpiry() time.Time {
+	if s.pathEntry == nil || s.pathEntry.Path == nil || s.pathEntry.Path.ExpTime == 0 {
+		return time.Time{}
+	}
+	return s.pathEntry.Path.Expiry()
+}
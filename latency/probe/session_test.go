@@ -0,0 +1,74 @@
+package probe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/sciond"
+)
+
+// Session of the given mode and probe size on a path with the given MTU, without a socket
+func testSession(t *testing.T, mode string, size int, mtu uint16) *session {
+	t.Helper()
+	local, remote := testAddrs()
+	s := &session{local: local, remote: remote, mode: mode, size: size}
+	if err := s.usePath(&sciond.PathReplyEntry{Path: &sciond.FwdPathMeta{Mtu: mtu}}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCheckRTTBackwardsClock(t *testing.T) {
+	// Wall clock times, as now returns them with CLOCK_WALL
+	sent := now(CLOCK_WALL)
+	for _, step := range []time.Duration{-time.Second, -time.Nanosecond, 0} {
+		if err := checkRTT(sent, sent.Add(step)); err == nil {
+			t.Errorf("checkRTT accepted an RTT of %s", step)
+		}
+	}
+	if err := checkRTT(sent, sent.Add(time.Millisecond)); err != nil {
+		t.Errorf("checkRTT rejected an RTT of 1ms: %v", err)
+	}
+}
+
+func TestNowMonotonic(t *testing.T) {
+	// Time.String shows the monotonic reading as m=, RTTs only survive a wall clock step with it
+	if got := now(CLOCK_WALL).String(); strings.Contains(got, " m=") {
+		t.Errorf("now(%s) = %s kept the monotonic reading", CLOCK_WALL, got)
+	}
+	if got := now(CLOCK_MONOTONIC).String(); !strings.Contains(got, " m=") {
+		t.Errorf("now(%s) = %s has no monotonic reading", CLOCK_MONOTONIC, got)
+	}
+}
+
+func TestLargeSize(t *testing.T) {
+	const size, mtu = 9000, 1472
+	for _, mode := range []string{MODE_SCMP, MODE_UDP} {
+		if s := testSession(t, mode, 64, mtu); len(s.sendBuff) != mtu {
+			t.Errorf("%s: send buffer of %d bytes for a small probe, want the MTU of %d", mode, len(s.sendBuff), mtu)
+		}
+		s := testSession(t, mode, size, mtu)
+		if len(s.sendBuff) < size+PROBE_HEADROOM || len(s.recvBuff) < size+PROBE_HEADROOM {
+			t.Errorf("%s: buffers of %d and %d bytes for a probe of %d bytes", mode, len(s.sendBuff),
+				len(s.recvBuff), size)
+		}
+		// Serialized in full and rejected before it is written, not cut short or failing to serialize
+		var err error
+		if mode == MODE_UDP {
+			_, _, err = s.probeUDP()
+		} else {
+			_, _, err = s.probeSCMP()
+		}
+		if !errors.Is(err, ErrTooLarge) || !strings.Contains(err.Error(), "does not fit the path MTU") {
+			t.Errorf("%s: probe of %d bytes failed with %v, want ErrTooLarge", mode, size, err)
+		}
+		if s.bytesSent != 0 {
+			t.Errorf("%s: %d bytes sent", mode, s.bytesSent)
+		}
+		if fit, err := s.fitSize(); err != nil || fit <= 0 || fit > mtu {
+			t.Errorf("%s: fitSize() = %d, %v, want a size that fits the MTU of %d", mode, fit, err, mtu)
+		}
+	}
+}